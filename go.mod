@@ -3,12 +3,14 @@ module github.com/evanboardway/hiwave_go
 go 1.16
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
+	github.com/google/uuid v1.3.0
 	github.com/gorilla/handlers v1.5.1
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/websocket v1.4.2
-	github.com/pion/rtcp v1.2.6 // indirect
-	github.com/pion/rtp v1.6.5 // indirect
+	github.com/pion/interceptor v0.0.13
+	github.com/pion/rtcp v1.2.6
+	github.com/pion/rtp v1.6.5
+	github.com/pion/turn/v2 v2.0.5
 	github.com/pion/webrtc/v2 v2.2.26
 	github.com/pion/webrtc/v3 v3.0.31
 	github.com/rs/cors v1.8.0
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 	str "strings"
 	"sync"
+	"time"
 
 	"github.com/evanboardway/hiwave_go/modules"
 	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -20,12 +27,82 @@ var (
 	nucleus *types.Nucleus
 )
 
+// Largest body a WHIP or WHEP request may have.
+var MAX_HTTP_SESSION_BODY int64 = 64 * 1024
+
 func main() {
+	config := &types.Config{}
+	flag.BoolVar(&config.PushToTalk, "push-to-talk", false, "Only route audio from clients holding the floor of their proximity group")
+	flag.DurationVar(&config.FloorMaxTalkTime, "floor-max-talk-time", 30*time.Second, "Longest a client may hold the floor before it is released")
+	flag.BoolVar(&config.SilenceSuppression, "silence-suppression", true, "Stop forwarding silent audio to listeners")
+	flag.IntVar(&config.SilenceLevel, "silence-level", 60, "Audio quieter than this many dBov counts as silence")
+	flag.DurationVar(&config.SilenceKeepalive, "silence-keepalive", 400*time.Millisecond, "How often silence is still forwarded as a keepalive")
+	flag.Float64Var(&config.REDLossThreshold, "red-loss-threshold", 5, "Loss percentage at which listeners are sent redundant (RED) audio")
+	flag.BoolVar(&config.PoliteServer, "polite-server", true, "Roll back the server's offer when it collides with a client's")
+	flag.DurationVar(&config.NegotiationWindow, "negotiation-window", 200*time.Millisecond, "Batch track changes made within this window into one renegotiation")
+	flag.IntVar(&config.TransceiverPoolSize, "transceiver-pool", 8, "Idle transceivers each client keeps to reuse for peers coming into range")
+	flag.IntVar(&config.ICEUDPPort, "ice-udp-port", 0, "Mux every peer connection over this UDP port, 0 for a port per connection")
+	flag.IntVar(&config.ICETCPPort, "ice-tcp-port", 0, "Mux ICE over TCP on this port, 0 disables ICE over TCP")
+	nat1To1IPs := flag.String("nat-1to1-ips", "", "Comma separated public IPs to advertise when behind a 1:1 NAT")
+	flag.StringVar(&config.NAT1To1CandidateType, "nat-1to1-candidate-type", "host", "Advertise the NAT IPs as host or srflx candidates")
+	iceInterfaces := flag.String("ice-interfaces", "", "Comma separated network interfaces to gather candidates on, all when empty")
+	iceServers := flag.String("ice-servers", "stun:stun.stunprotocol.org", "Comma separated STUN server URLs")
+	turnServers := flag.String("turn-servers", "", "Comma separated TURN server URLs handed out with time limited credentials")
+	flag.StringVar(&config.TURNSecret, "turn-secret", "", "Secret shared with the TURN servers for the TURN REST credential scheme")
+	flag.DurationVar(&config.TURNCredentialTTL, "turn-ttl", 12*time.Hour, "How long issued TURN credentials stay valid")
+	flag.StringVar(&config.TURNListen, "turn-listen", "", "UDP address for the embedded TURN server, which is disabled when empty")
+	flag.StringVar(&config.TURNPublicIP, "turn-public-ip", "", "Public IP of the embedded TURN server and its relays")
+	flag.StringVar(&config.TURNRealm, "turn-realm", "hiwave", "Realm of the embedded TURN server")
+	flag.IntVar(&config.TURNRelayMinPort, "turn-relay-min-port", 49152, "Lowest port the embedded TURN server relays on")
+	flag.IntVar(&config.TURNRelayMaxPort, "turn-relay-max-port", 65535, "Highest port the embedded TURN server relays on")
+	flag.DurationVar(&config.ICERestartDelay, "ice-restart-delay", 2*time.Second, "How long a disconnected client is given to recover before ICE is restarted")
+	flag.IntVar(&config.ICERestartAttempts, "ice-restart-attempts", 3, "ICE restarts attempted before a failed client is disconnected")
+	flag.DurationVar(&config.ResumeGrace, "resume-grace", 30*time.Second, "How long a client whose websocket dropped can be resumed for, 0 to disable")
+	flag.BoolVar(&config.BandwidthEstimation, "bandwidth-estimation", true, "Shed or slow peers a listener's estimated bandwidth can't carry")
+	flag.StringVar(&config.RecordingDir, "recording-dir", "recordings", "Directory recordings are written to")
+	flag.DurationVar(&config.RecordingRetention, "recording-retention", 0, "How long recordings are kept, 0 keeps them forever")
+	flag.StringVar(&config.BeaconsFile, "beacons", "", "JSON file listing beacons to start with the server")
+	flag.StringVar(&config.PromptsDir, "prompts", "", "Directory of Ogg Opus join, leave and floor granted prompts")
+	flag.StringVar(&config.AdminToken, "admin-token", "", "Bearer token for the admin API, which is disabled when empty")
+	flag.StringVar(&config.HTTPSessionToken, "http-session-token", "", "Bearer token for WHIP and WHEP, which are disabled when empty")
+	flag.Parse()
+
+	config.NAT1To1IPs = splitList(*nat1To1IPs)
+	config.ICEInterfaces = splitList(*iceInterfaces)
+	config.ICEServers = splitList(*iceServers)
+	config.TURNServers = splitList(*turnServers)
+
 	// Clients will be registered in the nucleus. Information coming from the SFU will go through the nucleus.
-	nucleus = types.CreateNucleus()
+	nucleus = types.CreateNucleus(config)
 
 	go modules.Enable(nucleus)
 
+	if err := modules.ConfigureICE(nucleus); err != nil {
+		log.Fatalf("Error configuring ICE: %s", err)
+	}
+
+	if config.TURNListen != "" {
+		if err := modules.StartTURNServer(nucleus); err != nil {
+			log.Fatalf("Error starting TURN server: %s", err)
+		}
+	}
+
+	if config.RecordingRetention > 0 {
+		go modules.PruneRecordings(nucleus)
+	}
+
+	if config.PromptsDir != "" {
+		if err := modules.LoadPrompts(nucleus, config.PromptsDir); err != nil {
+			log.Printf("Error loading prompts: %s", err)
+		}
+	}
+
+	if config.BeaconsFile != "" {
+		if err := modules.LoadBeacons(nucleus, config.BeaconsFile); err != nil {
+			log.Printf("Error loading beacons: %s", err)
+		}
+	}
+
 	fmt.Println("Hiwave server started")
 
 	// Connect to ws '/' for stats
@@ -33,6 +110,26 @@ func main() {
 
 	http.HandleFunc("/websocket", websocketHandler)
 
+	// GET /turn-credentials bearing the token from the websocket's session event
+	http.HandleFunc("/turn-credentials", turnCredentialsHandler)
+
+	// POST /whip and /whep with an SDP offer as the body, optionally ?lat=<latitude>&lng=<longitude>.
+	// DELETE /whip/<session> and /whep/<session> end a session, PATCH with a location moves it.
+	// All of them bear the HTTP session token.
+	http.HandleFunc("/whip", httpSessionHandler(types.HTTP_SESSION_WHIP))
+	http.HandleFunc("/whip/", httpSessionHandler(types.HTTP_SESSION_WHIP))
+	http.HandleFunc("/whep", httpSessionHandler(types.HTTP_SESSION_WHEP))
+	http.HandleFunc("/whep/", httpSessionHandler(types.HTTP_SESSION_WHEP))
+
+	// GET /admin/turn
+	http.HandleFunc("/admin/turn", turnStatsHandler)
+
+	// POST /admin/recordings?uuid=<client uuid>&action=start|stop
+	http.HandleFunc("/admin/recordings", recordingsHandler)
+
+	// GET /admin/beacons, POST /admin/beacons with a beacon as the body, DELETE /admin/beacons?uuid=<beacon uuid>
+	http.HandleFunc("/admin/beacons", beaconsHandler)
+
 	http.ListenAndServe(":5000", nil)
 }
 
@@ -56,6 +153,250 @@ func statsHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// Split a comma separated flag, dropping empty entries.
+func splitList(list string) []string {
+	items := make([]string, 0)
+	for _, item := range str.Split(list, ",") {
+		if item = str.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// GET /turn-credentials, in the shape of the TURN REST API. Only connected websocket clients
+// are issued credentials, bearing the token they were sent in their session event.
+func turnCredentialsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentials, err := modules.ClientTURNCredentials(nucleus, str.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err == modules.ErrUnknownSession {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(credentials)
+}
+
+func turnStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if nucleus.TURN == nil {
+		http.Error(w, "TURN server is not running", http.StatusNotFound)
+		return
+	}
+
+	nucleus.TURN.Mutex.Lock()
+	defer nucleus.TURN.Mutex.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(nucleus.TURN)
+}
+
+// WHIP and WHEP: a single HTTP offer and answer instead of the websocket signaling.
+func httpSessionHandler(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !bearerAuthorized(r, nucleus.Config.HTTPSessionToken) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		resource := str.Trim(str.TrimPrefix(r.URL.Path, "/"+kind), "/")
+
+		if resource == "" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			createHTTPSession(w, r, kind)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodDelete:
+			if err := modules.EndHTTPSession(nucleus, kind, resource); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+
+		case http.MethodPatch:
+			// Candidates aren't trickled, answers carry all of them.
+			if !str.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+				http.Error(w, "only location updates are supported", http.StatusUnsupportedMediaType)
+				return
+			}
+
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_HTTP_SESSION_BODY))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+
+			if err := modules.UpdateHTTPSessionLocation(nucleus, kind, resource, string(body)); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func createHTTPSession(w http.ResponseWriter, r *http.Request, kind string) {
+	if !str.HasPrefix(r.Header.Get("Content-Type"), "application/sdp") {
+		http.Error(w, "offer must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	offer, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_HTTP_SESSION_BODY))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var location *types.LocationData
+	if lat, lng := r.URL.Query().Get("lat"), r.URL.Query().Get("lng"); lat != "" || lng != "" {
+		location = &types.LocationData{}
+		if location.Latitude, err = strconv.ParseFloat(lat, 64); err == nil {
+			location.Longitude, err = strconv.ParseFloat(lng, 64)
+		}
+		if err != nil {
+			http.Error(w, "lat and lng must both be numbers", http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, answer, err := modules.CreateHTTPSession(nucleus, kind, string(offer), location)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", "/"+kind+"/"+client.HTTPSession.Resource)
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(answer))
+}
+
+// Only requests bearing the configured admin token may use the admin API.
+func adminAuthorized(r *http.Request) bool {
+	return bearerAuthorized(r, nucleus.Config.AdminToken)
+}
+
+// Whether the request bears the token, never when no token is configured.
+func bearerAuthorized(r *http.Request, token string) bool {
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) == 1
+}
+
+func recordingsHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	clientUUID, err := uuid.Parse(r.URL.Query().Get("uuid"))
+	if err != nil {
+		http.Error(w, "invalid uuid", http.StatusBadRequest)
+		return
+	}
+
+	nucleus.Mutex.RLock()
+	client := nucleus.Clients[clientUUID]
+	nucleus.Mutex.RUnlock()
+
+	if client == nil {
+		http.Error(w, "client not found", http.StatusNotFound)
+		return
+	}
+
+	var state *types.RecordingState
+	switch r.URL.Query().Get("action") {
+	case "start":
+		state, err = modules.StartRecording(client)
+	case "stop":
+		state, err = modules.StopRecording(client)
+	default:
+		http.Error(w, "action must be start or stop", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func beaconsHandler(w http.ResponseWriter, r *http.Request) {
+	if !adminAuthorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(modules.ListBeacons(nucleus))
+
+	case http.MethodPost:
+		config := &types.BeaconConfig{}
+		if err := json.NewDecoder(r.Body).Decode(config); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		beacon, err := modules.CreateBeacon(nucleus, config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[uuid.UUID]*types.BeaconConfig{beacon.UUID: config})
+
+	case http.MethodDelete:
+		beaconUUID, err := uuid.Parse(r.URL.Query().Get("uuid"))
+		if err != nil {
+			http.Error(w, "invalid uuid", http.StatusBadRequest)
+			return
+		}
+
+		if err := modules.RemoveBeacon(nucleus, beaconUUID); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
 func websocketHandler(w http.ResponseWriter, r *http.Request) {
 	// Upgrade HTTP request to Websocket
 	unsafeConn, err := upgrader.Upgrade(w, r, nil)
@@ -66,18 +407,27 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) {
 
 	remoteAddr := str.Split(r.RemoteAddr, ":")[0]
 
+	// Wrap socket in a mutex that can lock the socket for write.
+	safeConn := &types.ThreadSafeWriter{Conn: unsafeConn, Mutex: sync.RWMutex{}}
+
+	// A socket presenting a resume token takes over the client it was issued to.
+	if token := r.URL.Query().Get("resume"); token != "" {
+		if client := modules.ResumeClient(nucleus, token, safeConn, remoteAddr); client != nil {
+			go modules.Reader(client)
+			return
+		}
+		log.Printf("Resume token from %s is unknown or expired, connecting a new client\n", remoteAddr)
+	}
+
 	nucleus.Mutex.RLock()
 	for _, client := range nucleus.Clients {
 		if client.IpAddr == remoteAddr {
 			log.Printf("Prevented simultaneous connection from address %s with uuid %s\n %+v\n", remoteAddr, client.UUID, client)
-			nucleus.Unsubscribe <- client
+			go modules.EndClient(client)
 		}
 	}
 	nucleus.Mutex.RUnlock()
 
-	// Wrap socket in a mutex that can lock the socket for write.
-	safeConn := &types.ThreadSafeWriter{unsafeConn, sync.RWMutex{}}
-
 	// Create a new client. Give it the socket and the nucleus's phone number
 	newClient := types.NewClient(safeConn, nucleus, remoteAddr)
 
@@ -90,6 +440,9 @@ func websocketHandler(w http.ResponseWriter, r *http.Request) {
 	// Tell the nucleus who the client is.
 	nucleus.Subscribe <- newClient
 
+	// Give the client the token to resume with if its socket drops.
+	modules.SendSession(newClient)
+
 }
 
 // Client disconnects from audio:
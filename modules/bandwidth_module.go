@@ -0,0 +1,258 @@
+package modules
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/pion/rtcp"
+)
+
+var (
+	// Bounds on a listener's estimated bandwidth, in bits per second.
+	MIN_BANDWIDTH   = 16000.0
+	MAX_BANDWIDTH   = 2000000.0
+	START_BANDWIDTH = 256000.0
+
+	// The lowest bitrate a speaker is asked to send Opus at, below it tracks are shed instead.
+	MIN_OPUS_BITRATE = 12000.0
+
+	// Loss below which the estimate grows and above which it shrinks.
+	LOW_LOSS  = 0.02
+	HIGH_LOSS = 0.10
+
+	// How much more bandwidth than a shed track needs before it is restored.
+	RESTORE_HEADROOM = 1.2
+
+	// How often a listener's tracks are checked against its estimate.
+	REBALANCE_INTERVAL = time.Second
+)
+
+type BitrateRequest struct {
+	// Bits per second the client should send its audio at, zero to lift the limit
+	Bitrate float64
+}
+
+// Fold a transport wide feedback packet into the listener's estimate.
+func handleTransportCC(listener *types.Client, feedback *rtcp.TransportLayerCC) {
+	if feedback.PacketStatusCount == 0 {
+		return
+	}
+
+	lost := 0
+	for _, chunk := range feedback.PacketChunks {
+		switch chunk := chunk.(type) {
+		case *rtcp.RunLengthChunk:
+			if chunk.PacketStatusSymbol == rtcp.TypeTCCPacketNotReceived {
+				lost += int(chunk.RunLength)
+			}
+		case *rtcp.StatusVectorChunk:
+			for _, symbol := range chunk.SymbolList {
+				if symbol == rtcp.TypeTCCPacketNotReceived {
+					lost++
+				}
+			}
+		}
+	}
+
+	// The last chunk can be padded past the packet count.
+	lossRatio := math.Min(1, float64(lost)/float64(feedback.PacketStatusCount))
+	updateEstimate(listener, lossRatio)
+}
+
+// Cap the listener's estimate at the bitrate its REMB reports.
+func handleREMB(listener *types.Client, remb *rtcp.ReceiverEstimatedMaximumBitrate) {
+	listener.Bandwidth.Mutex.Lock()
+	listener.Bandwidth.REMB = float64(remb.Bitrate)
+	listener.Bandwidth.Mutex.Unlock()
+
+	updateEstimate(listener, -1)
+}
+
+// Grow the estimate while loss is low and shrink it in proportion to loss when it is high.
+// A negative loss ratio only reapplies the bounds.
+func updateEstimate(listener *types.Client, lossRatio float64) {
+	if !listener.Nucleus.Config.BandwidthEstimation {
+		return
+	}
+
+	estimate := &listener.Bandwidth
+	estimate.Mutex.Lock()
+
+	if estimate.Bitrate == 0 {
+		estimate.Bitrate = START_BANDWIDTH
+	}
+
+	if lossRatio >= 0 {
+		estimate.LossRatio = lossRatio
+		if lossRatio < LOW_LOSS {
+			estimate.Bitrate *= 1.05
+		} else if lossRatio > HIGH_LOSS {
+			estimate.Bitrate *= 1 - 0.5*lossRatio
+		}
+	}
+
+	estimate.Bitrate = math.Max(MIN_BANDWIDTH, math.Min(MAX_BANDWIDTH, estimate.Bitrate))
+	if estimate.REMB > 0 {
+		estimate.Bitrate = math.Min(estimate.Bitrate, estimate.REMB)
+	}
+
+	due := time.Since(estimate.Rebalanced) >= REBALANCE_INTERVAL
+	if due {
+		estimate.Rebalanced = time.Now()
+	}
+	available := estimate.Bitrate
+
+	estimate.Mutex.Unlock()
+
+	if due {
+		rebalance(listener, available)
+	}
+}
+
+type listenerTrack struct {
+	speaker  *types.Client
+	bundle   *types.AudioBundle
+	distance float64
+}
+
+// Fit the tracks the listener hears into the bandwidth available to it. When every track
+// can't have the minimum Opus bitrate the farthest are shed, the rest share what is left.
+func rebalance(listener *types.Client, available float64) {
+	tracks := listenerTracks(listener)
+	if len(tracks) == 0 {
+		return
+	}
+
+	demand := 0.0
+	for _, track := range tracks {
+		demand += track.speaker.Outbound.Bitrate()
+	}
+
+	// Nearest first, they are the last to be shed and the first to be restored.
+	sort.Slice(tracks, func(i, j int) bool {
+		return tracks[i].distance < tracks[j].distance
+	})
+
+	kept := int(available / MIN_OPUS_BITRATE)
+	if kept > len(tracks) {
+		kept = len(tracks)
+	}
+
+	share := 0.0
+	if demand > available && kept > 0 {
+		share = available / float64(kept)
+	}
+
+	for i, track := range tracks {
+		track.bundle.StatsMutex.Lock()
+		wasShed := track.bundle.Shed
+		shed := i >= kept
+
+		// A shed track is only restored once there is room to spare for it.
+		if wasShed && !shed && float64(i+1)*MIN_OPUS_BITRATE*RESTORE_HEADROOM > available {
+			shed = true
+		}
+
+		track.bundle.Shed = shed
+		track.bundle.Bitrate = share
+		track.bundle.StatsMutex.Unlock()
+
+		if shed != wasShed {
+			sendShedState(listener, track.speaker.UUID, shed)
+		}
+
+		requestBitrate(track.speaker)
+	}
+}
+
+// The tracks forwarded to the listener and how far away their speakers are. Speakers
+// without a location sort last.
+func listenerTracks(listener *types.Client) []*listenerTrack {
+	tracks := make([]*listenerTrack, 0)
+
+	listener.Nucleus.Mutex.RLock()
+	defer listener.Nucleus.Mutex.RUnlock()
+
+	for peer_uuid, peer := range listener.Nucleus.Clients {
+		if peer_uuid == listener.UUID {
+			continue
+		}
+
+		peer.RCMutex.RLock()
+		bundle := peer.RegisteredClients[listener.UUID]
+		peer.RCMutex.RUnlock()
+
+		if bundle == nil {
+			continue
+		}
+
+		distance := math.Inf(1)
+		if listener.CurrentLocation != nil && peer.CurrentLocation != nil {
+			distance = types.Distance(listener.CurrentLocation, peer.CurrentLocation)
+		}
+
+		tracks = append(tracks, &listenerTrack{speaker: peer, bundle: bundle, distance: distance})
+	}
+
+	return tracks
+}
+
+// Ask the speaker to send at the smallest share any of its listeners can take. The speaker
+// is only told when the bitrate changes by more than a tenth.
+func requestBitrate(speaker *types.Client) {
+	bitrate := 0.0
+
+	speaker.RCMutex.RLock()
+	for _, bundle := range speaker.RegisteredClients {
+		bundle.StatsMutex.RLock()
+		if !bundle.Shed && bundle.Bitrate > 0 && (bitrate == 0 || bundle.Bitrate < bitrate) {
+			bitrate = bundle.Bitrate
+		}
+		bundle.StatsMutex.RUnlock()
+	}
+	speaker.RCMutex.RUnlock()
+
+	speaker.Bandwidth.Mutex.Lock()
+	previous := speaker.Bandwidth.Requested
+	changed := (bitrate == 0) != (previous == 0) || math.Abs(bitrate-previous) > previous/10
+	if changed {
+		speaker.Bandwidth.Requested = bitrate
+	}
+	speaker.Bandwidth.Mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	speaker.WriteChan <- &types.WebsocketMessage{
+		Event:   "bitrate_request",
+		Payload: &BitrateRequest{Bitrate: bitrate},
+	}
+}
+
+// Tell the listener a peer's audio has been shed or restored.
+func sendShedState(listener *types.Client, peer uuid.UUID, shed bool) {
+	event := "peer_restored"
+	if shed {
+		event = "peer_shed"
+		log.Printf("Shedding client %s's audio to client %s\n", peer, listener.UUID)
+	}
+
+	listener.WriteChan <- &types.WebsocketMessage{
+		Event:   event,
+		Data:    peer.String(),
+		Payload: &types.PeerPayload{UUID: peer},
+	}
+}
+
+// Whether the listener's bandwidth has no room for the track.
+func isShed(bundle *types.AudioBundle) bool {
+	bundle.StatsMutex.RLock()
+	defer bundle.StatsMutex.RUnlock()
+
+	return bundle.Shed
+}
@@ -0,0 +1,268 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+)
+
+var (
+	// How often beacons check which riders are within their radius.
+	BEACON_UPDATE_INTERVAL = time.Second
+)
+
+// Create the beacons listed in a JSON file.
+func LoadBeacons(nucleus *types.Nucleus, path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	configs := make([]*types.BeaconConfig, 0)
+	if err := json.Unmarshal(raw, &configs); err != nil {
+		return err
+	}
+
+	for _, config := range configs {
+		if _, err := CreateBeacon(nucleus, config); err != nil {
+			log.Printf("Error creating beacon %s: %s", config.Name, err)
+		}
+	}
+	return nil
+}
+
+// Add a beacon to the nucleus. It takes part like any other client with a location,
+// riders inside its radius are registered to it and hear its files on loop.
+func CreateBeacon(nucleus *types.Nucleus, config *types.BeaconConfig) (*types.Client, error) {
+	if len(config.Files) == 0 {
+		return nil, errors.New("beacon has no files")
+	}
+	if config.Radius <= 0 {
+		return nil, errors.New("beacon radius must be positive")
+	}
+	for _, file := range config.Files {
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+	}
+
+	beacon := types.NewClient(nil, nucleus, "")
+	beacon.Avatar = "beacon"
+	beacon.CurrentLocation = &types.LocationData{
+		Latitude:  config.Latitude,
+		Longitude: config.Longitude,
+	}
+	beacon.Beacon = &types.Beacon{
+		Config:  config,
+		Stop:    make(chan bool),
+		Stopped: make(chan bool),
+	}
+
+	go drainBeaconWrites(beacon)
+	go RouteAudioToClients(beacon)
+
+	nucleus.Subscribe <- beacon
+
+	go playBeacon(beacon)
+	go beaconConnect(beacon)
+
+	log.Printf("Created beacon %s (%s)\n", beacon.UUID, config.Name)
+	return beacon, nil
+}
+
+// Stop a beacon and remove it from the nucleus.
+func RemoveBeacon(nucleus *types.Nucleus, beaconUUID uuid.UUID) error {
+	nucleus.Mutex.RLock()
+	beacon := nucleus.Clients[beaconUUID]
+	nucleus.Mutex.RUnlock()
+
+	if beacon == nil || beacon.Beacon == nil {
+		return errors.New("beacon not found")
+	}
+
+	beacon.Beacon.End.Do(func() {
+		close(beacon.Beacon.Stop)
+	})
+	<-beacon.Beacon.Stopped
+
+	log.Printf("Removed beacon %s (%s)\n", beacon.UUID, beacon.Beacon.Config.Name)
+	return nil
+}
+
+// Every beacon currently in the nucleus.
+func ListBeacons(nucleus *types.Nucleus) map[uuid.UUID]*types.BeaconConfig {
+	beacons := make(map[uuid.UUID]*types.BeaconConfig)
+
+	nucleus.Mutex.RLock()
+	for clientUUID, client := range nucleus.Clients {
+		if client.Beacon != nil {
+			beacons[clientUUID] = client.Beacon.Config
+		}
+	}
+	nucleus.Mutex.RUnlock()
+
+	return beacons
+}
+
+// Register riders within the beacon's radius to it and unregister those that leave.
+// Owns the beacon's lifecycle, cleaning up once the beacon is stopped.
+func beaconConnect(beacon *types.Client) {
+	ticker := time.NewTicker(BEACON_UPDATE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-beacon.Beacon.Stop:
+			stopBeacon(beacon)
+			return
+		case <-ticker.C:
+		}
+
+		beacon.Nucleus.Mutex.RLock()
+		riders := make(map[uuid.UUID]*types.Client)
+		for riderUUID, rider := range beacon.Nucleus.Clients {
+			rider.PCMutex.RLock()
+			if rider.PeerConnection != nil && rider.Beacon == nil && rider.Listens() {
+				riders[riderUUID] = rider
+			}
+			rider.PCMutex.RUnlock()
+		}
+		beacon.Nucleus.Mutex.RUnlock()
+
+		// Riders that have closed their peer connection or left have nothing to unregister from.
+		beacon.RCMutex.Lock()
+		for riderUUID := range beacon.RegisteredClients {
+			if riders[riderUUID] == nil {
+				delete(beacon.RegisteredClients, riderUUID)
+			}
+		}
+		beacon.RCMutex.Unlock()
+
+		for riderUUID, rider := range riders {
+			withinRadius := rider.CurrentLocation != nil &&
+				types.Distance(beacon.CurrentLocation, rider.CurrentLocation) <= beacon.Beacon.Config.Radius &&
+				!beacon.Nucleus.IsBlocked(beacon, rider)
+
+			beacon.RCMutex.RLock()
+			registered := beacon.RegisteredClients[riderUUID]
+			beacon.RCMutex.RUnlock()
+
+			if registered != nil && !withinRadius {
+				unregister(beacon, rider)
+			} else if registered == nil && withinRadius && !awaitingSlot(rider, beacon) && canHear(beacon, rider) {
+				register(beacon, rider)
+				sendBeaconLocation(beacon, rider)
+			}
+		}
+	}
+}
+
+// Unregister every rider, leave the nucleus and stop routing audio.
+func stopBeacon(beacon *types.Client) {
+	beacon.RCMutex.RLock()
+	listeners := make([]*types.Client, 0)
+	for _, bundle := range beacon.RegisteredClients {
+		listeners = append(listeners, bundle.Listener)
+	}
+	beacon.RCMutex.RUnlock()
+
+	for _, listener := range listeners {
+		unregister(beacon, listener)
+	}
+
+	beacon.Nucleus.Unsubscribe <- beacon
+	<-beacon.RemovedFromNucleus
+
+	beacon.StopRoutingAudio <- true
+
+	// Nothing can write to the beacon once it has left the nucleus.
+	close(beacon.Beacon.Stopped)
+}
+
+// Tell a rider where the beacon is, the same way peers share their location.
+func sendBeaconLocation(beacon *types.Client, rider *types.Client) {
+	rider.WriteChan <- &types.WebsocketMessage{
+		Event: "peer_location",
+		Payload: &types.LocationBundle{
+			UUID:     beacon.UUID,
+			Location: beacon.CurrentLocation,
+			Avatar:   beacon.Avatar,
+		},
+	}
+}
+
+// Play the beacon's files on loop, feeding them into the beacon's inbound audio as RTP.
+func playBeacon(beacon *types.Client) {
+	header := rtp.Header{
+		Version:        2,
+		PayloadType:    111,
+		SequenceNumber: uint16(rand.Uint32()),
+		Timestamp:      rand.Uint32(),
+		SSRC:           rand.Uint32(),
+	}
+
+	next := time.Now()
+	for {
+		for _, file := range beacon.Beacon.Config.Files {
+			stopped := false
+
+			err := readOggOpus(file, func(payload []byte, duration time.Duration) bool {
+				packet, err := (&rtp.Packet{Header: header, Payload: payload}).Marshal()
+				if err != nil {
+					log.Printf("Error marshaling beacon packet: %s", err)
+					return true
+				}
+
+				header.SequenceNumber++
+				header.Timestamp += uint32(duration.Seconds() * 48000)
+
+				// Pace the packets in real time.
+				next = next.Add(duration)
+				time.Sleep(time.Until(next))
+
+				select {
+				case beacon.InboundAudio <- packet:
+					return true
+				case <-beacon.Beacon.Stop:
+					stopped = true
+					return false
+				}
+			})
+
+			if stopped {
+				return
+			}
+
+			if err != nil {
+				log.Printf("Error playing beacon file %s: %s", file, err)
+
+				// Don't spin on a file that can't be read.
+				select {
+				case <-time.After(BEACON_UPDATE_INTERVAL):
+					next = time.Now()
+				case <-beacon.Beacon.Stop:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Beacons have no socket, everything sent to them is dropped.
+func drainBeaconWrites(beacon *types.Client) {
+	for {
+		select {
+		case <-beacon.WriteChan:
+		case <-beacon.Beacon.Stopped:
+			return
+		}
+	}
+}
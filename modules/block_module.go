@@ -0,0 +1,75 @@
+package modules
+
+import (
+	"log"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+)
+
+// Block (or unblock) another user. Blocked pairs are never registered to each other and
+// don't receive each other's location. Locate and connect tears down existing registrations.
+// Users are blocked by uuid, which a resumed client keeps, so a block lasts as long as
+// either client does and is gone once one of them leaves for good.
+func setBlocked(client *types.Client, message *types.WebsocketMessage, blocked bool) error {
+	peerUUID, err := decodePeer(message)
+	if err != nil {
+		return err
+	}
+
+	if peerUUID == client.UUID {
+		return types.NewProtocolError(types.ERROR_INVALID_PAYLOAD, "clients can't block themselves")
+	}
+
+	nucleus := client.Nucleus
+
+	nucleus.Mutex.RLock()
+	peer := nucleus.Clients[peerUUID]
+	nucleus.Mutex.RUnlock()
+
+	nucleus.BlocksMutex.Lock()
+	if blocked {
+		if peer == nil {
+			nucleus.BlocksMutex.Unlock()
+			return types.NewProtocolError(types.ERROR_INVALID_PAYLOAD, "user %s is not connected", peerUUID)
+		}
+
+		if nucleus.Blocks[client.UUID] == nil {
+			nucleus.Blocks[client.UUID] = make(map[uuid.UUID]bool)
+		}
+		nucleus.Blocks[client.UUID][peerUUID] = true
+	} else {
+		delete(nucleus.Blocks[client.UUID], peerUUID)
+	}
+
+	blockList := make([]uuid.UUID, 0)
+	for blockedUUID := range nucleus.Blocks[client.UUID] {
+		blockList = append(blockList, blockedUUID)
+	}
+	nucleus.BlocksMutex.Unlock()
+
+	log.Printf("Client %s blocked %s: %t\n", client.UUID, peerUUID, blocked)
+
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "blocked_users",
+		Payload: blockList,
+	}
+	return nil
+}
+
+// Forget a client that has left for good, both its blocks and the blocks against it. Its
+// uuid never comes back, so they could never apply again.
+func forgetBlocks(client *types.Client) {
+	nucleus := client.Nucleus
+
+	nucleus.BlocksMutex.Lock()
+	defer nucleus.BlocksMutex.Unlock()
+
+	delete(nucleus.Blocks, client.UUID)
+	for blocker, blocked := range nucleus.Blocks {
+		delete(blocked, client.UUID)
+		if len(blocked) == 0 {
+			delete(nucleus.Blocks, blocker)
+		}
+	}
+}
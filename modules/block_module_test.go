@@ -0,0 +1,59 @@
+package modules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+func TestBlocks(t *testing.T) {
+	nucleus := types.CreateNucleus(&types.Config{ResumeGrace: time.Minute})
+
+	newClient := func() *types.Client {
+		socket, _ := socketPair(t)
+		client := types.NewClient(socket, nucleus, "198.51.100.1:1000")
+		nucleus.Clients[client.UUID] = client
+		go func() {
+			for range client.WriteChan {
+			}
+		}()
+		return client
+	}
+	block := func(client *types.Client, peer *types.Client, blocked bool) {
+		if err := setBlocked(client, &types.WebsocketMessage{Data: peer.UUID.String()}, blocked); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Clients behind the same address are told apart.
+	alice, bob, carol := newClient(), newClient(), newClient()
+
+	block(alice, bob, true)
+	if !nucleus.IsBlocked(alice, bob) || !nucleus.IsBlocked(bob, alice) {
+		t.Errorf("block doesn't apply both ways")
+	}
+	if nucleus.IsBlocked(alice, carol) || nucleus.IsBlocked(bob, carol) {
+		t.Errorf("block applies to a client sharing the address")
+	}
+
+	// A resumed client keeps its uuid, and with it its blocks.
+	socket, _ := socketPair(t)
+	suspendClient(bob, bob.Socket)
+	if ResumeClient(nucleus, bob.Resume.Token, socket, "203.0.113.5:2000") != bob || !nucleus.IsBlocked(alice, bob) {
+		t.Errorf("block didn't survive a resume")
+	}
+
+	block(alice, bob, false)
+	if nucleus.IsBlocked(alice, bob) {
+		t.Errorf("unblocked pair is still blocked")
+	}
+
+	// Blocks go with the client that leaves, either side of them.
+	block(alice, bob, true)
+	block(carol, alice, true)
+	forgetBlocks(alice)
+	if len(nucleus.Blocks) != 0 {
+		t.Errorf("blocks = %v, expected the departed client's gone", nucleus.Blocks)
+	}
+}
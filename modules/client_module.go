@@ -13,10 +13,15 @@ import (
 func Reader(client *types.Client) {
 	fmt.Printf("%s reader started\n", client.UUID)
 
+	// The reader only reads the socket it started with, a resume starts a new one.
+	client.Resume.Mutex.Lock()
+	socket := client.Socket
+	client.Resume.Mutex.Unlock()
+
 	// If the loop ever breaks (can no longer read from the client socket)
-	// we remove the client from the nucleus and close the socket.
+	// the client is suspended until it resumes or its grace period runs out.
 	defer func() {
-		shutdownClient(client)
+		suspendClient(client, socket)
 	}()
 
 	// Read message from the socket, determine where it should go.
@@ -24,9 +29,9 @@ func Reader(client *types.Client) {
 		message := &types.WebsocketMessage{}
 
 		// Lock the mutex, read from the socket, unlock the mutex.
-		client.Socket.Mutex.RLock()
-		_, raw, err := client.Socket.Conn.ReadMessage()
-		client.Socket.Mutex.RUnlock()
+		socket.Mutex.RLock()
+		_, raw, err := socket.Conn.ReadMessage()
+		socket.Mutex.RUnlock()
 
 		// Handle errors on message read, decode the raw messsage.
 		if err != nil {
@@ -35,69 +40,152 @@ func Reader(client *types.Client) {
 			break
 		} else if err := json.Unmarshal(raw, &message); err != nil {
 			log.Printf("Error unmarshaling: %+v", err)
+			sendError(client, message, types.NewProtocolError(types.ERROR_INVALID_MESSAGE, "%s", err))
+			continue
 		}
 
-		switch message.Event {
-		case "wrtc_connect":
-			createPeerConnection(client)
-			break
+		if err := handleMessage(client, message); err != nil {
+			sendError(client, message, err)
+		} else if message.ID != nil {
+			sendAck(client, message)
+		}
+	}
+}
 
-		case "wrtc_offer":
-			handleOffer(client, message)
-			break
+// Route a message from the client to its handler. Errors are sent back to the client.
+func handleMessage(client *types.Client, message *types.WebsocketMessage) error {
+	var err error
 
-		case "wrtc_disconnect":
-			handleDisconnect(client)
-			break
+	switch message.Event {
+	case "hello":
+		err = handleHello(client, message)
+		break
 
-		case "wrtc_answer":
-			handleAnswer(client, message)
-			break
+	case "ice_servers":
+		err = sendICEServers(client)
+		break
 
-		case "wrtc_candidate":
-			handleIceCandidate(client, message)
-			break
+	case "wrtc_connect":
+		err = createPeerConnection(client)
+		break
 
-		case "voice":
-			register(client, client)
-			break
+	case "wrtc_offer":
+		err = handleOffer(client, message)
+		break
 
-		case "mute":
-			// unregister(client, client)
-			shutdownClient(client)
-			break
+	case "wrtc_disconnect":
+		err = handleDisconnect(client)
+		break
 
-		case "wrtc_renegotiation_needed":
-			handleRenegotiation(client, message)
-			break
+	case "wrtc_answer":
+		err = handleAnswer(client, message)
+		break
 
-		case "update_location":
-			updateClientLocation(client, message)
-			break
+	case "wrtc_candidate":
+		err = handleIceCandidate(client, message)
+		break
 
-		case "set_current_avatar":
-			fmt.Printf("Avatar set %+v\n", message)
-			client.Avatar = message.Data
-			break
+	case "voice", "diagnostic_start":
+		err = startDiagnostic(client)
+		break
+
+	case "diagnostic_stop":
+		stopDiagnostic(client)
+		break
+
+	case "mute":
+		setMuted(client, true)
+		break
+
+	case "unmute":
+		setMuted(client, false)
+		break
+
+	case "deafen":
+		setDeafened(client, true)
+		break
+
+	case "undeafen":
+		setDeafened(client, false)
+		break
+
+	case "mute_peer":
+		err = setPeerMuted(client, message, true)
+		break
+
+	case "unmute_peer":
+		err = setPeerMuted(client, message, false)
+		break
+
+	case "floor_request":
+		requestFloor(client)
+		break
+
+	case "floor_release":
+		releaseFloor(client)
+		break
+
+	case "block_user":
+		err = setBlocked(client, message, true)
+		break
+
+	case "unblock_user":
+		err = setBlocked(client, message, false)
+		break
+
+	case "record_start":
+		_, err = StartRecording(client)
+		break
+
+	case "record_stop":
+		_, err = StopRecording(client)
+		break
+
+	case "conversation_record_start":
+		err = startConversation(client)
+		break
+
+	case "conversation_opt_out":
+		err = optOutOfConversation(client, message)
+		break
+
+	case "conversation_record_stop":
+		var state *types.ConversationState
+		if state, err = stopConversation(client); err == nil {
+			sendConversationStopped(client, state)
 		}
+		break
+
+	case "wrtc_renegotiation_needed":
+		err = handleRenegotiation(client, message)
+		break
+
+	case "update_location":
+		err = updateClientLocation(client, message)
+		break
+
+	case "set_current_avatar":
+		fmt.Printf("Avatar set %+v\n", message)
+		client.Avatar, err = decodeAvatar(message)
+		break
 
+	default:
+		err = types.NewProtocolError(types.ERROR_UNKNOWN_EVENT, "unknown event %q", message.Event)
 	}
+
+	return err
 }
 
 // Write to socket synchronously (unbuffered chan)
 func Writer(client *types.Client) {
 	fmt.Printf("%s writer started\n", client.UUID)
 
-	defer func() {
-		shutdownClient(client)
-	}()
-
+	// The writer outlives the client's sockets, messages are kept while it has none.
 	for {
-		data := <-client.WriteChan
-		err := client.Socket.Conn.WriteJSON(data)
-		if err != nil {
-			// if writing to the socket fails, function returns and defer block is called
-			log.Printf("Write error %+v", err)
+		select {
+		case data := <-client.WriteChan:
+			writeMessage(client, data)
+		case <-client.Resume.Ended:
 			return
 		}
 	}
@@ -105,27 +193,54 @@ func Writer(client *types.Client) {
 
 func register(client *types.Client, registree *types.Client) {
 
+	// Send the speaker's codec when the listener has it, otherwise one it can be transcoded to.
+	mimeType, transcode, ok := listenerCodec(client, registree)
+	if !ok {
+		log.Printf("Client %s has no codec for client %s's audio\n", registree.UUID, client.UUID)
+		return
+	}
+
 	// add track to client, add track to global list of senders.
-	newTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "sfu_audio", client.UUID.String())
+	newTrack, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: mimeType}, "sfu_audio", client.UUID.String())
 	if err != nil {
 		log.Println(err)
 	}
 
-	transceiver, err := registree.PeerConnection.AddTransceiverFromTrack(newTrack, webrtc.RTPTransceiverInit{
-		Direction: webrtc.RTPTransceiverDirectionSendonly})
-	if err != nil {
+	slot, reused, err := acquireSlot(registree, newTrack)
+	if err == errNoFreeSlot {
+		// The listener can't be renegotiated, the peer is skipped until a slot is released.
+		awaitSlot(registree, client)
+		return
+	} else if err != nil {
 		log.Println(err)
+		return
 	}
 
 	audioBundle := &types.AudioBundle{
-		Transceiver: transceiver,
+		Transceiver: slot.Transceiver,
 		Track:       newTrack,
+		Slot:        slot,
+		Listener:    registree,
+		Transcode:   transcode,
 	}
 
+	slot.Mutex.Lock()
+	slot.Speaker = client
+	slot.Bundle = audioBundle
+	slot.Mutex.Unlock()
+
 	client.RCMutex.Lock()
 	client.RegisteredClients[registree.UUID] = audioBundle
 	client.RCMutex.Unlock()
 	log.Printf("Registered client %s to client %s\n", registree.UUID, client.UUID)
+
+	if reused {
+		sendTrackMapping(registree, slot, client)
+	}
+
+	if client.UUID != registree.UUID && client.Beacon == nil {
+		playPrompt(registree, "joined")
+	}
 }
 
 func unregister(client *types.Client, unregistree *types.Client) {
@@ -135,19 +250,25 @@ func unregister(client *types.Client, unregistree *types.Client) {
 	delete(client.RegisteredClients, unregistree.UUID)
 	client.RCMutex.Unlock()
 
-	log.Printf("Unregistree audio bundle: %+v cli: %s\n", unregistreeBundle, client.UUID)
-
-	if err := unregistree.PeerConnection.RemoveTrack(unregistreeBundle.Transceiver.Sender()); err != nil {
-		log.Printf("Error removing track on unregistree peer connection %s\n", err)
+	// Only publishing sessions are registered to listeners without listening back.
+	if unregistreeBundle == nil {
+		return
 	}
 
-	unregistreeBundle.Transceiver.Stop()
+	log.Printf("Unregistree audio bundle: %+v cli: %s\n", unregistreeBundle, client.UUID)
+
+	releaseSlot(unregistree, unregistreeBundle.Slot, unregistreeBundle.Track.Codec())
 
 	log.Printf("Unregistered client %s from client %s\n", unregistree.UUID, client.UUID)
 
+	if client.UUID != unregistree.UUID && client.Beacon == nil {
+		playPrompt(unregistree, "left")
+	}
+
 	client.WriteChan <- &types.WebsocketMessage{
-		Event: "wrtc_remove_stream",
-		Data:  unregistree.UUID.String(),
+		Event:   "wrtc_remove_stream",
+		Data:    unregistree.UUID.String(),
+		Payload: &types.PeerPayload{UUID: unregistree.UUID},
 	}
 }
 
@@ -167,7 +288,7 @@ func locateAndConnect(client *types.Client) {
 			filtered_clients := make(map[uuid.UUID]*types.Client)
 			for _, peer := range client.Nucleus.Clients {
 				peer.PCMutex.RLock()
-				if peer.PeerConnection != nil && peer.UUID != client.UUID {
+				if peer.PeerConnection != nil && peer.UUID != client.UUID && client.Publishes() && peer.Listens() {
 					filtered_clients[peer.UUID] = peer
 				}
 				peer.PCMutex.RUnlock()
@@ -176,7 +297,9 @@ func locateAndConnect(client *types.Client) {
 
 			// Add a check to make sure that the peer has a peer connection object
 			for peer_uuid, peer := range filtered_clients {
-				within_range := types.WithinRange(client.CurrentLocation, peer.CurrentLocation)
+				// Blocked pairs are treated as out of range in both directions.
+				within_range := types.WithinRange(client.CurrentLocation, peer.CurrentLocation) &&
+					!client.Nucleus.IsBlocked(client, peer)
 
 				client.RCMutex.RLock()
 				registered := client.RegisteredClients[peer_uuid]
@@ -185,15 +308,17 @@ func locateAndConnect(client *types.Client) {
 				if registered != nil {
 					if within_range == false {
 						client.WriteChan <- &types.WebsocketMessage{
-							Event: "peer",
-							Data:  "disconnected peer" + peer.UUID.String(),
+							Event:   "peer",
+							Data:    "disconnected peer" + peer.UUID.String(),
+							Payload: &types.PeerStatePayload{UUID: peer.UUID, State: types.PEER_DISCONNECTED},
 						}
 						unregister(client, peer)
 					}
-				} else if within_range {
+				} else if within_range && !awaitingSlot(peer, client) && canHear(client, peer) {
 					client.WriteChan <- &types.WebsocketMessage{
-						Event: "peer",
-						Data:  "connected peer" + peer.UUID.String(),
+						Event:   "peer",
+						Data:    "connected peer" + peer.UUID.String(),
+						Payload: &types.PeerStatePayload{UUID: peer.UUID, State: types.PEER_CONNECTED},
 					}
 					register(client, peer)
 				}
@@ -203,12 +328,36 @@ func locateAndConnect(client *types.Client) {
 }
 
 func RouteAudioToClients(client *types.Client) {
+	red := newREDForwarder()
 	for {
 		select {
 		case packet := <-client.InboundAudio:
+			// A radio check echoes audio no matter who else can hear it.
+			diagnosticPacket(client, packet)
+
+			// Muted clients aren't heard, and in push to talk mode only the holder of the floor is.
+			if client.IsMuted() || !floorAllows(client) {
+				break
+			}
+
+			recordPacket(client, packet)
+			recordConversationPacket(client, packet)
+
+			// Listeners don't need to be sent silence.
+			if !suppressSilence(client, packet) {
+				break
+			}
+
+			red.next(client, packet)
+			client.Outbound.Add(len(packet))
+
 			client.RCMutex.RLock()
 			for _, registreeBundle := range client.RegisteredClients {
-				registreeBundle.Track.Write(packet)
+				listener := registreeBundle.Listener
+				if listener.IsDeafened() || listener.HasMuted(client.UUID) || isShed(registreeBundle) {
+					continue
+				}
+				registreeBundle.Track.Write(red.packetFor(client, registreeBundle))
 			}
 			client.RCMutex.RUnlock()
 			break
@@ -219,12 +368,12 @@ func RouteAudioToClients(client *types.Client) {
 	}
 }
 
-func updateClientLocation(client *types.Client, message *types.WebsocketMessage) {
+func updateClientLocation(client *types.Client, message *types.WebsocketMessage) error {
 
 	location := &types.LocationData{}
 
-	if err := json.Unmarshal([]byte(message.Data), &location); err != nil {
-		log.Print(err)
+	if err := decodePayload(message, location); err != nil {
+		return err
 	}
 
 	bundle := &types.LocationBundle{
@@ -233,55 +382,101 @@ func updateClientLocation(client *types.Client, message *types.WebsocketMessage)
 		Avatar:   client.Avatar,
 	}
 
-	locationMarshaled, err := json.Marshal(bundle)
-	if err != nil {
-		log.Printf("Error marshaling client location: %s", err)
-	}
-
 	client.CurrentLocation = location
 
 	client.Nucleus.Mutex.RLock()
 	for uuid, peer := range client.Nucleus.Clients {
-		if uuid != client.UUID {
+		if uuid != client.UUID && !client.Nucleus.IsBlocked(client, peer) {
 			peer.WriteChan <- &types.WebsocketMessage{
-				Event: "peer_location",
-				Data:  string(locationMarshaled),
+				Event:   "peer_location",
+				Payload: bundle,
 			}
 		}
 	}
 	client.Nucleus.Mutex.RUnlock()
+
+	return nil
 }
 
-func handleDisconnect(client *types.Client) {
+func handleDisconnect(client *types.Client) error {
+	// Without a peer connection nothing is running to be stopped.
+	if currentPeerConnection(client) == nil {
+		return errNoPeerConnection
+	}
+
 	// Signal locate and connect goroutine to shutdown
 	client.StopLAC <- true
 
 	// Wait until the clients locate and connect goroutine signals that it has stopped
 	<-client.StoppedLAC
 
+	// A client without audio can't hold the floor
+	releaseFloor(client)
+
+	if state := finishRecording(client); state != nil {
+		sendRecordingState(client, "recording_stopped", state)
+	}
+
+	if state, err := stopConversation(client); err == nil {
+		sendConversationStopped(client, state)
+	}
+
+	stopDiagnostic(client)
+
+	// Stop sending spatial updates
+	client.StopSpatial <- true
+
 	client.PCMutex.Lock()
 
 	client.StopRoutingAudio <- true
 
-	// Make a copy of registered clients.
-	RegisteredClientsCopy := make(map[uuid.UUID]*types.Client)
+	// Every speaker sending audio to this client, found from their side since WHEP listeners
+	// don't hold bundles for the speakers registered to them.
+	speakers := make([]*types.Client, 0)
+	client.Nucleus.Mutex.RLock()
+	for _, peer := range client.Nucleus.Clients {
+		peer.RCMutex.RLock()
+		_, listening := peer.RegisteredClients[client.UUID]
+		peer.RCMutex.RUnlock()
 
+		if listening {
+			speakers = append(speakers, peer)
+		}
+	}
+	client.Nucleus.Mutex.RUnlock()
+
+	// And every listener this client sends audio to, WHIP sessions in particular are
+	// registered one way only.
+	listeners := make([]*types.Client, 0)
 	client.RCMutex.RLock()
-	for uuid := range client.RegisteredClients {
-		RegisteredClientsCopy[uuid] = client.Nucleus.Clients[uuid]
+	for _, bundle := range client.RegisteredClients {
+		if bundle != nil && bundle.Listener != nil {
+			listeners = append(listeners, bundle.Listener)
+		}
 	}
 	client.RCMutex.RUnlock()
 
-	// Unregister all currently registered clients from this one.
-	for uuid := range RegisteredClientsCopy {
-		unregister(client.Nucleus.Clients[uuid], client)
+	// Unregister this client from the speakers, and the listeners from it.
+	for _, speaker := range speakers {
+		unregister(speaker, client)
+	}
+	for _, listener := range listeners {
+		unregister(client, listener)
 	}
 
 	client.PeerConnection.Close()
 
 	client.PeerConnection = nil
+	client.Announcements = nil
+
+	// Pooled transceivers belonged to the closed peer connection.
+	client.SlotsMutex.Lock()
+	client.IdleSlots = nil
+	client.SlotsMutex.Unlock()
 
 	client.PCMutex.Unlock()
+
+	return nil
 }
 
 func shutdownClient(client *types.Client) {
@@ -295,16 +490,31 @@ func shutdownClient(client *types.Client) {
 
 	log.Printf("%s Removed from nucleus, %+v", client.UUID, temp)
 
+	releaseFloor(client)
+	forgetBlocks(client)
+
+	// The writer may already be gone, so the client isn't told.
+	finishRecording(client)
+	stopConversation(client)
+	finishDiagnostic(client)
+
 	client.Nucleus.Mutex.RLock()
 	for uuid, peer := range client.Nucleus.Clients {
 		if uuid != client.UUID {
 			peer.WriteChan <- &types.WebsocketMessage{
-				Event: "peer_disconnected",
-				Data:  client.UUID.String(),
+				Event:   "peer_disconnected",
+				Data:    client.UUID.String(),
+				Payload: &types.PeerPayload{UUID: client.UUID},
 			}
 		}
 	}
 	client.Nucleus.Mutex.RUnlock()
 
-	client.Socket.Conn.Close()
+	// A resume may be swapping the socket.
+	client.Resume.Mutex.Lock()
+	socket := client.Socket
+	client.Resume.Mutex.Unlock()
+	if socket != nil {
+		socket.Conn.Close()
+	}
 }
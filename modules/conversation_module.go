@@ -0,0 +1,415 @@
+package modules
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+var (
+	// How often the membership of a recorded cluster is checked.
+	CONVERSATION_UPDATE_INTERVAL = time.Second
+)
+
+const (
+	conversationFile    = "conversation.ogg"
+	conversationSidecar = "conversation.json"
+)
+
+// Start recording the proximity cluster around the client. Participants are followed
+// as they join and leave until the client stops the recording.
+func startConversation(client *types.Client) error {
+	nucleus := client.Nucleus
+
+	nucleus.ConversationsMutex.Lock()
+	defer nucleus.ConversationsMutex.Unlock()
+
+	for _, conversation := range nucleus.Conversations {
+		if conversation.Initiator.UUID == client.UUID {
+			return types.NewProtocolError(types.ERROR_INVALID_STATE, "client is already recording a conversation")
+		}
+	}
+
+	id := uuid.New()
+	dir := filepath.Join(nucleus.Config.RecordingDir, fmt.Sprintf("%s_conversation_%s", nucleus.SessionID, id))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	conversation := &types.Conversation{
+		ID:           id,
+		Initiator:    client,
+		Dir:          dir,
+		Started:      time.Now(),
+		Participants: make(map[uuid.UUID]*types.ConversationTrack),
+		OptedOut:     make(map[uuid.UUID]bool),
+		Events:       make([]*types.ConversationEvent, 0),
+		Stop:         make(chan bool),
+	}
+	nucleus.Conversations[id] = conversation
+
+	log.Printf("Client %s started recording conversation %s\n", client.UUID, id)
+
+	go followConversation(conversation)
+	return nil
+}
+
+// Stop the conversation recording the client started. The participant tracks are mixed
+// into a single file and the sidecar is written in the background.
+func stopConversation(client *types.Client) (*types.ConversationState, error) {
+	nucleus := client.Nucleus
+
+	nucleus.ConversationsMutex.Lock()
+	var conversation *types.Conversation
+	for id, candidate := range nucleus.Conversations {
+		if candidate.Initiator.UUID == client.UUID {
+			conversation = candidate
+			delete(nucleus.Conversations, id)
+			break
+		}
+	}
+	nucleus.ConversationsMutex.Unlock()
+
+	if conversation == nil {
+		return nil, types.NewProtocolError(types.ERROR_INVALID_STATE, "client is not recording a conversation")
+	}
+
+	conversation.Stop <- true
+
+	stopped := time.Now()
+	state := &types.ConversationState{
+		ID:        conversation.ID,
+		Initiator: client.UUID,
+		Started:   conversation.Started,
+		Stopped:   &stopped,
+	}
+
+	go finishConversation(conversation, state.Stopped)
+	return state, nil
+}
+
+// Keep the conversation's participants in step with the initiator's proximity cluster.
+func followConversation(conversation *types.Conversation) {
+	ticker := time.NewTicker(CONVERSATION_UPDATE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		updateConversation(conversation)
+
+		select {
+		case <-conversation.Stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func updateConversation(conversation *types.Conversation) {
+	cluster := proximityCluster(conversation.Initiator)
+	joined := make([]*types.Client, 0)
+
+	conversation.Mutex.Lock()
+
+	for peerUUID, peer := range cluster {
+		track := conversation.Participants[peerUUID]
+		if (track != nil && track.Active) || conversation.OptedOut[peerUUID] {
+			continue
+		}
+
+		if track == nil {
+			file := peerUUID.String() + ".ogg"
+			writer, err := oggwriter.New(filepath.Join(conversation.Dir, file), 48000, 2)
+			if err != nil {
+				log.Printf("Error creating conversation track for %s: %s", peerUUID, err)
+				continue
+			}
+
+			track = &types.ConversationTrack{
+				Writer: writer,
+				File:   file,
+			}
+			conversation.Participants[peerUUID] = track
+		}
+
+		track.Active = true
+		addConversationEvent(conversation, peerUUID, peer.CurrentLocation, "joined")
+		joined = append(joined, peer)
+	}
+
+	left := false
+	for peerUUID, track := range conversation.Participants {
+		if track.Active && cluster[peerUUID] == nil {
+			track.Active = false
+			addConversationEvent(conversation, peerUUID, lastKnownLocation(conversation.Initiator.Nucleus, peerUUID), "left")
+			left = true
+		}
+	}
+
+	if len(joined) > 0 || left {
+		writeConversationSidecar(conversation, nil)
+	}
+
+	conversation.Mutex.Unlock()
+
+	// Everyone being recorded is told so.
+	state := &types.ConversationState{
+		ID:        conversation.ID,
+		Initiator: conversation.Initiator.UUID,
+		Started:   conversation.Started,
+	}
+
+	for _, peer := range joined {
+		peer.WriteChan <- &types.WebsocketMessage{
+			Event:   "conversation_recording",
+			Payload: state,
+		}
+	}
+}
+
+// Must be called with the conversation mutex locked.
+func addConversationEvent(conversation *types.Conversation, peerUUID uuid.UUID, location *types.LocationData, event string) {
+	now := time.Now()
+	conversationEvent := &types.ConversationEvent{
+		UUID:     peerUUID,
+		Event:    event,
+		Time:     now,
+		OffsetMs: now.Sub(conversation.Started).Milliseconds(),
+	}
+
+	// Copy the location since the client replaces it as they move.
+	if location != nil {
+		locationCopy := *location
+		conversationEvent.Location = &locationCopy
+	}
+
+	conversation.Events = append(conversation.Events, conversationEvent)
+}
+
+// The location of a client if they are still connected.
+func lastKnownLocation(nucleus *types.Nucleus, clientUUID uuid.UUID) *types.LocationData {
+	nucleus.Mutex.RLock()
+	defer nucleus.Mutex.RUnlock()
+
+	if client := nucleus.Clients[clientUUID]; client != nil {
+		return client.CurrentLocation
+	}
+	return nil
+}
+
+// Every client reachable from the client by following registrations in either direction.
+func proximityCluster(client *types.Client) map[uuid.UUID]*types.Client {
+	cluster := map[uuid.UUID]*types.Client{client.UUID: client}
+	queue := []*types.Client{client}
+
+	client.Nucleus.Mutex.RLock()
+	defer client.Nucleus.Mutex.RUnlock()
+
+	if client.Nucleus.Clients[client.UUID] == nil {
+		return make(map[uuid.UUID]*types.Client)
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for peerUUID, peer := range client.Nucleus.Clients {
+			if cluster[peerUUID] != nil {
+				continue
+			}
+
+			current.RCMutex.RLock()
+			hears := current.RegisteredClients[peerUUID] != nil
+			current.RCMutex.RUnlock()
+
+			peer.RCMutex.RLock()
+			heard := peer.RegisteredClients[current.UUID] != nil
+			peer.RCMutex.RUnlock()
+
+			if hears || heard {
+				cluster[peerUUID] = peer
+				queue = append(queue, peer)
+			}
+		}
+	}
+
+	return cluster
+}
+
+// Write an inbound RTP packet to every conversation the client is an active participant in.
+func recordConversationPacket(client *types.Client, packet []byte) {
+	client.Nucleus.ConversationsMutex.RLock()
+	defer client.Nucleus.ConversationsMutex.RUnlock()
+
+	var rtpPacket *rtp.Packet
+	for _, conversation := range client.Nucleus.Conversations {
+		conversation.Mutex.Lock()
+
+		track := conversation.Participants[client.UUID]
+		if track != nil && track.Active {
+			if rtpPacket == nil {
+				rtpPacket = &rtp.Packet{}
+				if err := rtpPacket.Unmarshal(packet); err != nil {
+					conversation.Mutex.Unlock()
+					log.Printf("Error unmarshaling packet for conversation: %s", err)
+					return
+				}
+
+				if !opusForRecording(client, rtpPacket) {
+					conversation.Mutex.Unlock()
+					return
+				}
+			}
+
+			// The first packet pins the track to the conversation's timeline, the writer
+			// places the rest by their RTP timestamps.
+			if !track.Started {
+				track.Offset = time.Since(conversation.Started)
+				track.Started = true
+			}
+
+			if err := track.Writer.WriteRTP(rtpPacket); err != nil {
+				log.Printf("Error writing conversation track %s: %s", track.File, err)
+			}
+		}
+
+		conversation.Mutex.Unlock()
+	}
+}
+
+// Close the participant tracks, mix them into a single time aligned file and write the
+// sidecar. Each participant becomes a logical stream of the file, the participant tracks
+// are removed once they have been mixed.
+func finishConversation(conversation *types.Conversation, stopped *time.Time) {
+	conversation.Mutex.Lock()
+	defer conversation.Mutex.Unlock()
+
+	inputs := make([]*oggMuxInput, 0)
+	for peerUUID, track := range conversation.Participants {
+		if track.Active {
+			track.Active = false
+			addConversationEvent(conversation, peerUUID, lastKnownLocation(conversation.Initiator.Nucleus, peerUUID), "left")
+		}
+
+		if err := track.Writer.Close(); err != nil {
+			log.Printf("Error closing conversation track %s: %s", track.File, err)
+		}
+
+		if track.Started {
+			track.Serial = uint32(len(inputs) + 1)
+			inputs = append(inputs, &oggMuxInput{
+				Path:   filepath.Join(conversation.Dir, track.File),
+				Serial: track.Serial,
+				Offset: track.Offset,
+			})
+		}
+	}
+
+	if err := muxOggOpus(filepath.Join(conversation.Dir, conversationFile), inputs); err != nil {
+		log.Printf("Error mixing conversation %s: %s", conversation.ID, err)
+	} else {
+		conversation.File = conversationFile
+		for _, track := range conversation.Participants {
+			if err := os.Remove(filepath.Join(conversation.Dir, track.File)); err != nil {
+				log.Printf("Error removing conversation track %s: %s", track.File, err)
+			}
+		}
+	}
+
+	writeConversationSidecar(conversation, stopped)
+
+	log.Printf("Finished recording conversation %s\n", conversation.ID)
+}
+
+// Leave a conversation the client is being recorded in. Everything recorded of the client
+// is deleted and it isn't recorded again if it rejoins the cluster.
+func optOutOfConversation(client *types.Client, message *types.WebsocketMessage) error {
+	optOut := &types.ConversationOptOut{}
+	if err := decodePayload(message, optOut); err != nil {
+		return err
+	}
+
+	client.Nucleus.ConversationsMutex.RLock()
+	conversation := client.Nucleus.Conversations[optOut.ID]
+	client.Nucleus.ConversationsMutex.RUnlock()
+
+	if conversation == nil {
+		return types.NewProtocolError(types.ERROR_INVALID_STATE, "no conversation %s is being recorded", optOut.ID)
+	}
+
+	conversation.Mutex.Lock()
+	defer conversation.Mutex.Unlock()
+
+	conversation.OptedOut[client.UUID] = true
+
+	if track := conversation.Participants[client.UUID]; track != nil {
+		if err := track.Writer.Close(); err != nil {
+			log.Printf("Error closing conversation track %s: %s", track.File, err)
+		}
+		if err := os.Remove(filepath.Join(conversation.Dir, track.File)); err != nil {
+			log.Printf("Error removing conversation track %s: %s", track.File, err)
+		}
+		delete(conversation.Participants, client.UUID)
+	}
+
+	// Where the client was and when is recorded too.
+	events := make([]*types.ConversationEvent, 0, len(conversation.Events))
+	for _, event := range conversation.Events {
+		if event.UUID != client.UUID {
+			events = append(events, event)
+		}
+	}
+	conversation.Events = events
+
+	writeConversationSidecar(conversation, nil)
+
+	log.Printf("Client %s opted out of conversation %s\n", client.UUID, conversation.ID)
+	return nil
+}
+
+// Must be called with the conversation mutex locked. The sidecar has no stop time while
+// the conversation is still being recorded.
+func writeConversationSidecar(conversation *types.Conversation, stopped *time.Time) {
+	sidecar := &types.ConversationSidecar{
+		ID:           conversation.ID,
+		Initiator:    conversation.Initiator.UUID,
+		Started:      conversation.Started,
+		Stopped:      stopped,
+		File:         conversation.File,
+		Participants: make([]*types.ConversationParticipant, 0),
+		Events:       conversation.Events,
+	}
+
+	for peerUUID, track := range conversation.Participants {
+		sidecar.Participants = append(sidecar.Participants, &types.ConversationParticipant{
+			UUID:     peerUUID,
+			Serial:   track.Serial,
+			OffsetMs: track.Offset.Milliseconds(),
+		})
+	}
+
+	sidecarMarshaled, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		log.Printf("Error marshaling conversation sidecar: %s", err)
+		return
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(conversation.Dir, conversationSidecar), sidecarMarshaled, 0644); err != nil {
+		log.Printf("Error writing conversation sidecar: %s", err)
+	}
+}
+
+func sendConversationStopped(client *types.Client, state *types.ConversationState) {
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "conversation_recording_stopped",
+		Payload: state,
+	}
+}
@@ -0,0 +1,204 @@
+package modules
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+// The pages of an Ogg file as (serial, header type, granule position).
+type oggPage struct {
+	serial     uint32
+	headerType byte
+	granule    uint64
+}
+
+func readOggPages(t *testing.T, path string) []oggPage {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pages := make([]oggPage, 0)
+	for len(data) > 0 {
+		if len(data) < 27 || string(data[:4]) != "OggS" {
+			t.Fatalf("malformed page at %d bytes from the end", len(data))
+		}
+		segments := int(data[26])
+		size := 27 + segments
+		for _, lacing := range data[27 : 27+segments] {
+			size += int(lacing)
+		}
+
+		pages = append(pages, oggPage{
+			serial:     binary.LittleEndian.Uint32(data[14:]),
+			headerType: data[5],
+			granule:    binary.LittleEndian.Uint64(data[6:]),
+		})
+		data = data[size:]
+	}
+	return pages
+}
+
+func TestConversationRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "conversation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nucleus := types.CreateNucleus(&types.Config{})
+	speaker := func() *types.Client {
+		client := types.NewClient(nil, nucleus, "")
+		nucleus.Clients[client.UUID] = client
+		go func() {
+			for range client.WriteChan {
+			}
+		}()
+		return client
+	}
+	alice, bob := speaker(), speaker()
+	alice.RegisteredClients[bob.UUID] = &types.AudioBundle{}
+
+	conversation := &types.Conversation{
+		ID:           uuid.New(),
+		Initiator:    alice,
+		Dir:          dir,
+		Started:      time.Now(),
+		Participants: make(map[uuid.UUID]*types.ConversationTrack),
+		OptedOut:     make(map[uuid.UUID]bool),
+		Events:       make([]*types.ConversationEvent, 0),
+		Stop:         make(chan bool),
+	}
+	nucleus.Conversations[conversation.ID] = conversation
+	updateConversation(conversation)
+
+	speak := func(client *types.Client, ssrc uint32, packets int) {
+		for index := 0; index < packets; index++ {
+			raw, err := (&rtp.Packet{
+				Header:  rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: uint16(index), Timestamp: uint32(index * 960), SSRC: ssrc},
+				Payload: []byte{0xfc, byte(index)},
+			}).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordConversationPacket(client, raw)
+		}
+	}
+
+	// Alice talks from the start, Bob starts a second in.
+	conversation.Participants[alice.UUID].Started = true
+	conversation.Participants[bob.UUID].Offset = time.Second
+	conversation.Participants[bob.UUID].Started = true
+	speak(alice, 1, 100)
+	speak(bob, 2, 50)
+
+	stopped := time.Now()
+	finishConversation(conversation, &stopped)
+
+	sidecarData, err := ioutil.ReadFile(filepath.Join(dir, conversationSidecar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar := &types.ConversationSidecar{}
+	if err := json.Unmarshal(sidecarData, sidecar); err != nil {
+		t.Fatal(err)
+	}
+	if sidecar.File != conversationFile || len(sidecar.Participants) != 2 {
+		t.Fatalf("sidecar has file %q and %d participants", sidecar.File, len(sidecar.Participants))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%d files recorded, expected the mixed file and the sidecar", len(files))
+	}
+
+	// The file is valid Ogg, checksums included.
+	file, err := os.Open(filepath.Join(dir, sidecar.File))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, _, err := oggreader.NewWith(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if _, _, err := reader.ParseNextPage(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	serials := make(map[uuid.UUID]uint32)
+	offsets := make(map[uuid.UUID]int64)
+	for _, participant := range sidecar.Participants {
+		serials[participant.UUID] = participant.Serial
+		offsets[participant.UUID] = participant.OffsetMs
+	}
+	if serials[alice.UUID] == 0 || serials[bob.UUID] == 0 || serials[alice.UUID] == serials[bob.UUID] {
+		t.Fatalf("participants have streams %v", serials)
+	}
+	if offsets[bob.UUID] != 1000 {
+		t.Errorf("bob starts %dms in, expected 1000ms", offsets[bob.UUID])
+	}
+
+	// Per stream: the beginning page, the comment header, then audio with the stream's
+	// offset applied to its granule positions and the last page marking its end.
+	type stream struct {
+		pages        int
+		audio        int
+		firstGranule uint64
+		ended        bool
+	}
+	streams := make(map[uint32]*stream)
+	var lastGranule uint64
+	for _, page := range readOggPages(t, filepath.Join(dir, sidecar.File)) {
+		s := streams[page.serial]
+		if s == nil {
+			s = &stream{}
+			streams[page.serial] = s
+		}
+
+		switch {
+		case s.pages == 0 && page.headerType != oggPageHeaderTypeBeginning:
+			t.Errorf("stream %d doesn't start with a beginning page", page.serial)
+		case s.pages > 1:
+			if s.audio == 0 {
+				s.firstGranule = page.granule
+			}
+			if page.granule < lastGranule {
+				t.Errorf("page at %d follows one at %d", page.granule, lastGranule)
+			}
+			lastGranule = page.granule
+			s.audio++
+			s.ended = page.headerType == oggPageHeaderTypeEnd
+		}
+		s.pages++
+	}
+
+	if len(streams) != 2 {
+		t.Fatalf("file holds %d streams, expected both speakers", len(streams))
+	}
+	aliceStream, bobStream := streams[serials[alice.UUID]], streams[serials[bob.UUID]]
+	if aliceStream.audio != 100 || bobStream.audio != 50 || !aliceStream.ended || !bobStream.ended {
+		t.Errorf("streams hold %d and %d packets, ended %t and %t", aliceStream.audio, bobStream.audio, aliceStream.ended, bobStream.ended)
+	}
+	if offset := bobStream.firstGranule - aliceStream.firstGranule; offset != 48000 {
+		t.Errorf("bob's stream is %d samples after alice's, expected 48000", offset)
+	}
+}
@@ -0,0 +1,215 @@
+package modules
+
+import (
+	"log"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/rtcp"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+var (
+	// How long a radio check runs before its result is reported.
+	DIAGNOSTIC_DURATION = 10 * time.Second
+)
+
+// Start a radio check: echo the client's audio back on a dedicated track and measure the link.
+func startDiagnostic(client *types.Client) error {
+	client.PCMutex.RLock()
+	peerConnection := client.PeerConnection
+	client.PCMutex.RUnlock()
+
+	if peerConnection == nil {
+		return types.NewProtocolError(types.ERROR_NO_PEER_CONNECTION, "client has no peer connection")
+	}
+
+	client.DiagMutex.Lock()
+	defer client.DiagMutex.Unlock()
+
+	if client.Diagnostic != nil {
+		return types.NewProtocolError(types.ERROR_INVALID_STATE, "diagnostic already running")
+	}
+
+	// The echo is sent back exactly as it was received.
+	mimeType := webrtc.MimeTypeOpus
+	if _, codec := client.InboundStream(); codec != "" {
+		mimeType = codec
+	}
+
+	track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: mimeType}, "diagnostic", "hiwave_diagnostic")
+	if err != nil {
+		return err
+	}
+
+	transceiver, err := peerConnection.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		return err
+	}
+
+	diagnostic := &types.Diagnostic{
+		Track:       track,
+		Transceiver: transceiver,
+		Started:     time.Now(),
+		ClockRate:   codecClockRate(mimeType),
+		Uplink:      types.NewStreamStats(codecClockRate(mimeType)),
+		RoundTrips:  make([]time.Duration, 0),
+	}
+	diagnostic.Timer = time.AfterFunc(DIAGNOSTIC_DURATION, func() {
+		stopDiagnostic(client)
+	})
+	client.Diagnostic = diagnostic
+
+	go readDiagnosticRTCP(diagnostic)
+
+	log.Printf("Started diagnostic for client %s\n", client.UUID)
+	return nil
+}
+
+// End the client's radio check and send them the result.
+func stopDiagnostic(client *types.Client) {
+	result := finishDiagnostic(client)
+	if result == nil {
+		return
+	}
+
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "diagnostic_result",
+		Payload: result,
+	}
+}
+
+// Remove the echo track and compile the measurements. Safe to call once the client's writer has stopped.
+func finishDiagnostic(client *types.Client) *types.DiagnosticResult {
+	client.DiagMutex.Lock()
+	diagnostic := client.Diagnostic
+	client.Diagnostic = nil
+	client.DiagMutex.Unlock()
+
+	if diagnostic == nil {
+		return nil
+	}
+
+	diagnostic.Timer.Stop()
+
+	client.PCMutex.RLock()
+	if client.PeerConnection != nil {
+		if err := client.PeerConnection.RemoveTrack(diagnostic.Transceiver.Sender()); err != nil {
+			log.Printf("Error removing diagnostic track: %s", err)
+		}
+	}
+	client.PCMutex.RUnlock()
+
+	if err := diagnostic.Transceiver.Stop(); err != nil {
+		log.Printf("Error stopping diagnostic transceiver: %s", err)
+	}
+
+	result := &types.DiagnosticResult{
+		DurationMs:            time.Since(diagnostic.Started).Milliseconds(),
+		UplinkPacketsReceived: diagnostic.Uplink.Received,
+		UplinkPacketsLost:     diagnostic.Uplink.Lost(),
+		UplinkJitterMs:        float64(diagnostic.Uplink.Jitter()) / float64(time.Millisecond),
+	}
+	if expected := diagnostic.Uplink.Expected(); expected > 0 {
+		result.UplinkLossPercent = 100 * float64(result.UplinkPacketsLost) / float64(expected)
+	}
+
+	diagnostic.Mutex.Lock()
+	result.DownlinkPacketsLost = diagnostic.DownlinkTotalLost
+	result.DownlinkLossPercent = 100 * float64(diagnostic.DownlinkFractionLost) / 256
+	result.DownlinkJitterMs = jitterMs(diagnostic.DownlinkJitter, diagnostic.ClockRate)
+	result.RoundTripSamples = len(diagnostic.RoundTrips)
+	if result.RoundTripSamples > 0 {
+		var total time.Duration
+		for _, roundTrip := range diagnostic.RoundTrips {
+			total += roundTrip
+		}
+		result.RoundTripMs = float64(total/time.Duration(result.RoundTripSamples)) / float64(time.Millisecond)
+	}
+	diagnostic.Mutex.Unlock()
+
+	log.Printf("Finished diagnostic for client %s: %+v\n", client.UUID, result)
+	return result
+}
+
+// Echo an inbound packet back to the client if a radio check is running.
+func diagnosticPacket(client *types.Client, packet []byte) {
+	client.DiagMutex.Lock()
+	diagnostic := client.Diagnostic
+	client.DiagMutex.Unlock()
+
+	if diagnostic == nil {
+		return
+	}
+
+	header := &rtp.Header{}
+	if err := header.Unmarshal(packet); err != nil {
+		log.Printf("Error unmarshaling diagnostic packet: %s", err)
+		return
+	}
+	diagnostic.Uplink.Update(header, time.Now())
+
+	if _, err := diagnostic.Track.Write(packet); err != nil {
+		log.Printf("Error echoing diagnostic packet: %s", err)
+	}
+}
+
+// Take round trip times and downlink quality from the client's receiver reports of the echo track.
+func readDiagnosticRTCP(diagnostic *types.Diagnostic) {
+	for {
+		packets, _, err := diagnostic.Transceiver.Sender().ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		now := time.Now()
+		for _, packet := range packets {
+			report, ok := packet.(*rtcp.ReceiverReport)
+			if !ok {
+				continue
+			}
+
+			diagnostic.Mutex.Lock()
+			for _, reception := range report.Reports {
+				diagnostic.DownlinkFractionLost = reception.FractionLost
+				diagnostic.DownlinkTotalLost = reception.TotalLost
+				diagnostic.DownlinkJitter = reception.Jitter
+
+				if roundTrip, ok := roundTripTime(now, reception); ok {
+					diagnostic.RoundTrips = append(diagnostic.RoundTrips, roundTrip)
+				}
+			}
+			diagnostic.Mutex.Unlock()
+		}
+	}
+}
+
+// Reported interarrival jitter, in the stream's timestamp units, in milliseconds.
+func jitterMs(jitter uint32, clockRate uint32) float64 {
+	if clockRate == 0 {
+		return 0
+	}
+	return float64(jitter) / (float64(clockRate) / 1000)
+}
+
+// Round trip time from a reception report, RFC 3550 section 6.4.1.
+func roundTripTime(now time.Time, reception rtcp.ReceptionReport) (time.Duration, bool) {
+	if reception.LastSenderReport == 0 {
+		return 0, false
+	}
+
+	// Middle 32 bits of the NTP timestamp, in units of 1/65536 seconds.
+	rtt := ntpMiddle(now) - reception.LastSenderReport - reception.Delay
+	if rtt > 1<<31 {
+		return 0, false
+	}
+	return time.Duration(rtt) * time.Second / 65536, true
+}
+
+func ntpMiddle(t time.Time) uint32 {
+	seconds := uint64(t.Unix()) + 2208988800
+	fraction := (uint64(t.Nanosecond()) << 32) / uint64(time.Second)
+	return uint32((seconds&0xffff)<<16 | fraction>>16)
+}
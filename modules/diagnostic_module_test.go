@@ -0,0 +1,37 @@
+package modules
+
+import (
+	"math"
+	"testing"
+
+	"github.com/pion/webrtc/v3"
+)
+
+func TestDownlinkJitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		codec    string
+		jitter   uint32
+		expected float64
+	}{
+		{"opus 48 kHz", webrtc.MimeTypeOpus, 480, 10},
+		{"red 48 kHz", MIME_TYPE_RED, 96, 2},
+		{"ulaw 8 kHz", webrtc.MimeTypePCMU, 80, 10},
+		{"alaw 8 kHz", webrtc.MimeTypePCMA, 8, 1},
+		{"g722 8 kHz clock", webrtc.MimeTypeG722, 160, 20},
+		{"lower case mime type", "audio/pcmu", 80, 10},
+		{"no jitter", webrtc.MimeTypePCMU, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if jitter := jitterMs(test.jitter, codecClockRate(test.codec)); math.Abs(jitter-test.expected) > 1e-9 {
+				t.Errorf("jitterMs() = %f, expected %f", jitter, test.expected)
+			}
+		})
+	}
+
+	if jitter := jitterMs(480, 0); jitter != 0 {
+		t.Errorf("jitterMs() without a clock rate = %f, expected 0", jitter)
+	}
+}
@@ -0,0 +1,208 @@
+package modules
+
+import (
+	"log"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+// Grant the client the floor if nobody in its proximity group holds it, otherwise queue the client.
+func requestFloor(client *types.Client) {
+	floor := client.Nucleus.Floor
+
+	floor.Mutex.Lock()
+	notices := make([]func(), 0)
+
+	if floor.Holders[client.UUID] == nil {
+		if conflictingHolder(client) == nil {
+			notices = grantFloor(client, notices)
+		} else {
+			position := queuePosition(floor, client)
+			if position < 0 {
+				floor.Queue = append(floor.Queue, client)
+				position = len(floor.Queue) - 1
+			}
+
+			log.Printf("Queued client %s for the floor at position %d\n", client.UUID, position)
+
+			state := &types.FloorState{
+				UUID:     client.UUID,
+				State:    "queued",
+				Position: position,
+			}
+			notices = append(notices, func() { sendFloorState(client, state) })
+		}
+	}
+
+	floor.Mutex.Unlock()
+	sendFloorNotices(notices)
+}
+
+// Take the floor (or the clients place in the queue) away from the client and hand it to whoever is next.
+func releaseFloor(client *types.Client) {
+	floor := client.Nucleus.Floor
+
+	floor.Mutex.Lock()
+	notices := releaseFloorLocked(client, make([]func(), 0))
+	floor.Mutex.Unlock()
+
+	sendFloorNotices(notices)
+}
+
+// Release the floor once the max talk time has elapsed, unless the grant the timer was
+// started for has already ended.
+func expireFloor(client *types.Client, generation uint64) {
+	floor := client.Nucleus.Floor
+
+	floor.Mutex.Lock()
+	notices := make([]func(), 0)
+	if grant := floor.Holders[client.UUID]; grant != nil && grant.Generation == generation {
+		log.Printf("Client %s reached the max talk time\n", client.UUID)
+		notices = releaseFloorLocked(client, notices)
+	}
+	floor.Mutex.Unlock()
+
+	sendFloorNotices(notices)
+}
+
+// Must be called with the floor mutex locked. The notices are sent once it is unlocked.
+func releaseFloorLocked(client *types.Client, notices []func()) []func() {
+	floor := client.Nucleus.Floor
+
+	if position := queuePosition(floor, client); position >= 0 {
+		floor.Queue = append(floor.Queue[:position], floor.Queue[position+1:]...)
+	}
+
+	grant := floor.Holders[client.UUID]
+	if grant == nil {
+		return notices
+	}
+
+	grant.Timer.Stop()
+	delete(floor.Holders, client.UUID)
+
+	log.Printf("Client %s released the floor after %s\n", client.UUID, time.Since(grant.Granted))
+
+	released := &types.FloorState{
+		UUID:  client.UUID,
+		State: "released",
+	}
+	notices = append(notices, func() { broadcastFloorState(client, released) })
+
+	// Serve the queue in order, skipping clients whose group still has a holder.
+	waiting := floor.Queue
+	floor.Queue = make([]*types.Client, 0)
+	for _, next := range waiting {
+		if conflictingHolder(next) == nil {
+			notices = grantFloor(next, notices)
+		} else {
+			floor.Queue = append(floor.Queue, next)
+		}
+	}
+
+	return notices
+}
+
+// Send floor state changes. Sending blocks on the recipients' writers, so it must happen
+// after the floor mutex is unlocked or a slow client would stall every speaker's audio.
+func sendFloorNotices(notices []func()) {
+	for _, notice := range notices {
+		notice()
+	}
+}
+
+// Whether audio from the client should be routed to its listeners. Beacons never need the floor.
+func floorAllows(client *types.Client) bool {
+	if !client.Nucleus.Config.PushToTalk || client.Beacon != nil {
+		return true
+	}
+	return client.Nucleus.Floor.Holds(client.UUID)
+}
+
+// Must be called with the floor mutex locked. The notices are sent once it is unlocked.
+func grantFloor(client *types.Client, notices []func()) []func() {
+	floor := client.Nucleus.Floor
+
+	floor.Generation++
+	generation := floor.Generation
+
+	floor.Holders[client.UUID] = &types.FloorGrant{
+		Client:     client,
+		Granted:    time.Now(),
+		Generation: generation,
+		Timer: time.AfterFunc(client.Nucleus.Config.FloorMaxTalkTime, func() {
+			expireFloor(client, generation)
+		}),
+	}
+
+	log.Printf("Granted the floor to client %s\n", client.UUID)
+
+	granted := &types.FloorState{
+		UUID:  client.UUID,
+		State: "granted",
+	}
+	return append(notices, func() {
+		broadcastFloorState(client, granted)
+
+		// A neutral cue that someone in the group took the floor.
+		client.Nucleus.Mutex.RLock()
+		for uuid, peer := range client.Nucleus.Clients {
+			if uuid != client.UUID && sameFloorGroup(client, peer) {
+				playPrompt(peer, "floor_granted")
+			}
+		}
+		client.Nucleus.Mutex.RUnlock()
+	})
+}
+
+// Find a client holding the floor in the same proximity group as the client.
+// Must be called with the floor mutex locked.
+func conflictingHolder(client *types.Client) *types.Client {
+	for _, grant := range client.Nucleus.Floor.Holders {
+		if grant.Client.UUID != client.UUID && sameFloorGroup(client, grant.Client) {
+			return grant.Client
+		}
+	}
+	return nil
+}
+
+func sameFloorGroup(client *types.Client, peer *types.Client) bool {
+	if client.CurrentLocation == nil || peer.CurrentLocation == nil {
+		return false
+	}
+	return types.WithinRange(client.CurrentLocation, peer.CurrentLocation)
+}
+
+func queuePosition(floor *types.Floor, client *types.Client) int {
+	for position, queued := range floor.Queue {
+		if queued.UUID == client.UUID {
+			return position
+		}
+	}
+	return -1
+}
+
+// Tell the client and every peer in its proximity group about a change to the floor.
+func broadcastFloorState(client *types.Client, state *types.FloorState) {
+	recipients := make([]*types.Client, 0)
+
+	client.Nucleus.Mutex.RLock()
+	for uuid, peer := range client.Nucleus.Clients {
+		if uuid == client.UUID || sameFloorGroup(client, peer) {
+			recipients = append(recipients, peer)
+		}
+	}
+	client.Nucleus.Mutex.RUnlock()
+
+	for _, peer := range recipients {
+		sendFloorState(peer, state)
+	}
+}
+
+func sendFloorState(client *types.Client, state *types.FloorState) {
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "floor_state",
+		Payload: state,
+	}
+}
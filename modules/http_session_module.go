@@ -0,0 +1,159 @@
+package modules
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+// Start a WHIP (publish) or WHEP (listen) session from the client's offer and return the
+// answer. The session takes part like any rider at the given location, if there is one.
+// Candidates aren't trickled, the answer carries all of the server's.
+func CreateHTTPSession(nucleus *types.Nucleus, kind string, offerSDP string, location *types.LocationData) (*types.Client, string, error) {
+	offer := webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offerSDP}
+
+	client := types.NewClient(nil, nucleus, "")
+	client.Avatar = kind
+	client.CurrentLocation = location
+	client.HTTPSession = &types.HTTPSession{
+		Kind:     kind,
+		Resource: types.NewToken(),
+		Stopped:  make(chan bool),
+	}
+
+	go drainSessionWrites(client)
+	nucleus.Subscribe <- client
+
+	if err := createPeerConnection(client); err != nil {
+		endHTTPSession(client)
+		return nil, "", err
+	}
+	peerConnection := currentPeerConnection(client)
+
+	// A WHEP listener can't be renegotiated, so every audio m-line it offered becomes a
+	// slot for a peer's audio up front.
+	if kind == types.HTTP_SESSION_WHEP {
+		if err := addSessionSlots(client, peerConnection, strings.Count(offerSDP, "m=audio")); err != nil {
+			endHTTPSession(client)
+			return nil, "", err
+		}
+	}
+
+	answer, err := answerSession(client, peerConnection, offer)
+	if err != nil {
+		endHTTPSession(client)
+		return nil, "", err
+	}
+
+	log.Printf("Started %s session %s\n", kind, client.UUID)
+	return client, answer, nil
+}
+
+// End a session and remove it from the nucleus.
+func EndHTTPSession(nucleus *types.Nucleus, kind string, resource string) error {
+	client, err := findHTTPSession(nucleus, kind, resource)
+	if err != nil {
+		return err
+	}
+
+	endHTTPSession(client)
+	return nil
+}
+
+// Move a session, the body is a location the same as update_location's.
+func UpdateHTTPSessionLocation(nucleus *types.Nucleus, kind string, resource string, location string) error {
+	client, err := findHTTPSession(nucleus, kind, resource)
+	if err != nil {
+		return err
+	}
+
+	return updateClientLocation(client, &types.WebsocketMessage{Event: "update_location", Data: location})
+}
+
+func findHTTPSession(nucleus *types.Nucleus, kind string, resource string) (*types.Client, error) {
+	nucleus.Mutex.RLock()
+	defer nucleus.Mutex.RUnlock()
+
+	for _, client := range nucleus.Clients {
+		session := client.HTTPSession
+		if session != nil && session.Kind == kind &&
+			subtle.ConstantTimeCompare([]byte(session.Resource), []byte(resource)) == 1 {
+			return client, nil
+		}
+	}
+	return nil, errors.New("session not found")
+}
+
+// Add idle sendonly transceivers for the m-lines of a WHEP offer to match.
+func addSessionSlots(client *types.Client, peerConnection *webrtc.PeerConnection, count int) error {
+	for i := 0; i < count; i++ {
+		idle, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus}, "sfu_idle", "sfu_idle")
+		if err != nil {
+			return err
+		}
+
+		transceiver, err := peerConnection.AddTransceiverFromTrack(idle, webrtc.RTPTransceiverInit{
+			Direction: webrtc.RTPTransceiverDirectionSendonly})
+		if err != nil {
+			return err
+		}
+
+		slot := &types.TransceiverSlot{Transceiver: transceiver}
+		go readSenderRTCP(client, slot)
+
+		client.SlotsMutex.Lock()
+		client.IdleSlots = append(client.IdleSlots, slot)
+		client.SlotsMutex.Unlock()
+	}
+
+	return nil
+}
+
+// Answer the session's offer once the server's candidates have been gathered.
+func answerSession(client *types.Client, peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription) (string, error) {
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return "", err
+	}
+	updateNegotiatedCodecs(client)
+
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gatheringComplete := webrtc.GatheringCompletePromise(peerConnection)
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	<-gatheringComplete
+
+	return peerConnection.LocalDescription().SDP, nil
+}
+
+// Tear down the session's peer connection and remove it from the nucleus, once.
+func endHTTPSession(client *types.Client) {
+	client.HTTPSession.End.Do(func() {
+		if currentPeerConnection(client) != nil {
+			handleDisconnect(client)
+		}
+		shutdownClient(client)
+		close(client.HTTPSession.Stopped)
+
+		log.Printf("Ended %s session %s\n", client.HTTPSession.Kind, client.UUID)
+	})
+}
+
+// Sessions have no socket, everything sent to them is dropped.
+func drainSessionWrites(client *types.Client) {
+	for {
+		select {
+		case <-client.WriteChan:
+		case <-client.HTTPSession.Stopped:
+			return
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package modules
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+// Keep the client's registrations through a network handover. A disconnected connection is
+// given a moment to recover by itself before ICE is restarted, a failed one is restarted
+// straight away. The peer connection is only torn down once the restarts run out. HTTP
+// sessions are never restarted, they end instead.
+func handleICEState(client *types.Client, peerConnection *webrtc.PeerConnection, connectionState webrtc.ICEConnectionState) {
+	switch connectionState {
+	case webrtc.ICEConnectionStateConnected, webrtc.ICEConnectionStateCompleted:
+		client.Negotiation.Mutex.Lock()
+		client.Negotiation.Restarts = 0
+		if client.Negotiation.RestartTimer != nil {
+			client.Negotiation.RestartTimer.Stop()
+			client.Negotiation.RestartTimer = nil
+		}
+		client.Negotiation.Mutex.Unlock()
+
+	case webrtc.ICEConnectionStateDisconnected:
+		client.Negotiation.Mutex.Lock()
+		if client.Negotiation.RestartTimer == nil {
+			client.Negotiation.RestartTimer = time.AfterFunc(client.Nucleus.Config.ICERestartDelay, func() {
+				client.Negotiation.Mutex.Lock()
+				client.Negotiation.RestartTimer = nil
+				client.Negotiation.Mutex.Unlock()
+
+				if peerConnection.ICEConnectionState() == webrtc.ICEConnectionStateDisconnected {
+					recoverICE(client, peerConnection)
+				}
+			})
+		}
+		client.Negotiation.Mutex.Unlock()
+
+	case webrtc.ICEConnectionStateFailed:
+		if client.HTTPSession != nil {
+			log.Println("Peer connection state failed. Ending HTTP session")
+			endHTTPSession(client)
+			return
+		}
+		if restartICE(client, peerConnection) {
+			return
+		}
+
+		client.WriteChan <- &types.WebsocketMessage{
+			Event: "wrtc_failed",
+		}
+		if closeErr := peerConnection.Close(); closeErr != nil {
+			log.Printf("Error closing the peer connection %s", closeErr)
+		}
+
+		log.Println("Peer connection state failed. Handling client disconnect")
+		handleDisconnect(client)
+	}
+}
+
+// Restart ICE on a connection that didn't recover by itself. HTTP sessions can't be
+// renegotiated, so theirs is ended instead.
+func recoverICE(client *types.Client, peerConnection *webrtc.PeerConnection) {
+	if client.HTTPSession != nil {
+		log.Printf("Ending disconnected %s session %s\n", client.HTTPSession.Kind, client.UUID)
+		endHTTPSession(client)
+		return
+	}
+	restartICE(client, peerConnection)
+}
+
+// Offer the client an ICE restart, or queue one behind an outstanding offer. Returns false
+// once the client has used up its restarts.
+func restartICE(client *types.Client, peerConnection *webrtc.PeerConnection) bool {
+	// The peer connection was replaced or closed in the meantime.
+	if currentPeerConnection(client) != peerConnection {
+		return true
+	}
+
+	client.Negotiation.Mutex.Lock()
+	if client.Negotiation.Restarts >= client.Nucleus.Config.ICERestartAttempts {
+		client.Negotiation.Mutex.Unlock()
+		return false
+	}
+	client.Negotiation.Restarts++
+	client.Negotiation.RestartICE = true
+	attempt := client.Negotiation.Restarts
+	client.Negotiation.Mutex.Unlock()
+
+	log.Printf("Restarting ICE for client %s, attempt %d\n", client.UUID, attempt)
+	negotiate(client, peerConnection)
+	return true
+}
+
+// Build the ICE settings every peer connection shares from the config. With a UDP (and TCP)
+// port set, all peer connections are muxed over it so that a container only needs that
+// port open.
+func ConfigureICE(nucleus *types.Nucleus) error {
+	config := nucleus.Config
+	settingEngine := &nucleus.SettingEngine
+
+	if config.ICEUDPPort != 0 {
+		udpListener, err := net.ListenUDP("udp", &net.UDPAddr{Port: config.ICEUDPPort})
+		if err != nil {
+			return err
+		}
+		settingEngine.SetICEUDPMux(webrtc.NewICEUDPMux(nil, udpListener))
+		log.Printf("ICE muxed over UDP port %d\n", config.ICEUDPPort)
+	}
+
+	if config.ICETCPPort != 0 {
+		tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{Port: config.ICETCPPort})
+		if err != nil {
+			return err
+		}
+		settingEngine.SetICETCPMux(webrtc.NewICETCPMux(nil, tcpListener, 8))
+		settingEngine.SetNetworkTypes([]webrtc.NetworkType{
+			webrtc.NetworkTypeUDP4,
+			webrtc.NetworkTypeUDP6,
+			webrtc.NetworkTypeTCP4,
+			webrtc.NetworkTypeTCP6,
+		})
+		log.Printf("ICE muxed over TCP port %d\n", config.ICETCPPort)
+	}
+
+	if len(config.NAT1To1IPs) > 0 {
+		candidateType, err := webrtc.NewICECandidateType(config.NAT1To1CandidateType)
+		if err != nil {
+			return err
+		}
+		if candidateType != webrtc.ICECandidateTypeHost && candidateType != webrtc.ICECandidateTypeSrflx {
+			return fmt.Errorf("NAT 1:1 IPs can't be advertised as %s candidates", candidateType)
+		}
+		if candidateType == webrtc.ICECandidateTypeSrflx && len(config.ICEServers) == 0 {
+			return fmt.Errorf("NAT 1:1 IPs advertised as srflx candidates need a STUN server, set -ice-servers")
+		}
+		settingEngine.SetNAT1To1IPs(config.NAT1To1IPs, candidateType)
+	}
+
+	if len(config.ICEInterfaces) > 0 {
+		interfaces := make(map[string]bool)
+		for _, name := range config.ICEInterfaces {
+			interfaces[name] = true
+		}
+		settingEngine.SetInterfaceFilter(func(name string) bool {
+			return interfaces[name]
+		})
+	}
+
+	return nil
+}
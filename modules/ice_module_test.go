@@ -0,0 +1,77 @@
+package modules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestConfigureICE(t *testing.T) {
+	stun := []string{"stun:stun.example.com:3478"}
+	public := []string{"198.51.100.7"}
+
+	tests := []struct {
+		name   string
+		config *types.Config
+		err    bool
+	}{
+		{"no NAT mapping", &types.Config{}, false},
+		{"host candidates", &types.Config{NAT1To1IPs: public, NAT1To1CandidateType: "host"}, false},
+		{"srflx candidates with STUN", &types.Config{NAT1To1IPs: public, NAT1To1CandidateType: "srflx", ICEServers: stun}, false},
+		{"srflx candidates without STUN", &types.Config{NAT1To1IPs: public, NAT1To1CandidateType: "srflx"}, true},
+		{"relay candidates", &types.Config{NAT1To1IPs: public, NAT1To1CandidateType: "relay", ICEServers: stun}, true},
+		{"unknown candidate type", &types.Config{NAT1To1IPs: public, NAT1To1CandidateType: "public"}, true},
+		{"candidate type without NAT mapping", &types.Config{NAT1To1CandidateType: "srflx"}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := ConfigureICE(&types.Nucleus{Config: test.config}); (err != nil) != test.err {
+				t.Errorf("ConfigureICE() error = %v, expected error %t", err, test.err)
+			}
+		})
+	}
+}
+
+func TestHTTPSessionICE(t *testing.T) {
+	tests := []struct {
+		name    string
+		recover func(*types.Client, *webrtc.PeerConnection)
+	}{
+		{"failed", func(client *types.Client, peerConnection *webrtc.PeerConnection) {
+			handleICEState(client, peerConnection, webrtc.ICEConnectionStateFailed)
+		}},
+		{"still disconnected after the delay", recoverICE},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nucleus := types.CreateNucleus(&types.Config{ICERestartAttempts: 3})
+			go Enable(nucleus)
+
+			client := types.NewClient(nil, nucleus, "")
+			client.HTTPSession = &types.HTTPSession{Kind: types.HTTP_SESSION_WHEP, Stopped: make(chan bool)}
+			go drainSessionWrites(client)
+			nucleus.Subscribe <- client
+
+			peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer peerConnection.Close()
+
+			test.recover(client, peerConnection)
+
+			select {
+			case <-client.HTTPSession.Stopped:
+			case <-time.After(5 * time.Second):
+				t.Fatal("session wasn't ended")
+			}
+			if client.Negotiation.Restarts != 0 || client.Negotiation.RestartICE {
+				t.Errorf("ICE restart attempted for an HTTP session")
+			}
+		})
+	}
+}
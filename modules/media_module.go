@@ -0,0 +1,142 @@
+package modules
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/twcc"
+	"github.com/pion/webrtc/v3"
+)
+
+const (
+	// Payload types offered for each codec, matching what browsers use.
+	OPUS_PAYLOAD_TYPE = 111
+	RED_PAYLOAD_TYPE  = 63
+
+	MIME_TYPE_RED = "audio/red"
+
+	TRANSPORT_CC_URI = "http://www.ietf.org/id/draft-holmer-rmcat-transport-wide-cc-extensions-01"
+)
+
+// RTP clock rate of a negotiated audio codec. G.722 uses 8 kHz despite sampling at 16 kHz.
+func codecClockRate(mimeType string) uint32 {
+	switch strings.ToLower(mimeType) {
+	case strings.ToLower(webrtc.MimeTypeG722), strings.ToLower(webrtc.MimeTypePCMU), strings.ToLower(webrtc.MimeTypePCMA):
+		return 8000
+	default:
+		return 48000
+	}
+}
+
+// The API peer connections are created from. Negotiates Opus with in-band FEC and Opus RED
+// (RFC 2198) for lossy links, G.722 and G.711 for low end and SIP derived clients, plus the
+// audio level header extension so that silence can be detected without decoding. Listeners
+// send transport wide congestion control and REMB feedback for their bandwidth estimates.
+func newWebRTCAPI(nucleus *types.Nucleus) (*webrtc.API, error) {
+	mediaEngine := &webrtc.MediaEngine{}
+
+	feedback := []webrtc.RTCPFeedback{{Type: webrtc.TypeRTCPFBTransportCC}, {Type: webrtc.TypeRTCPFBGoogREMB}}
+
+	for _, codec := range []webrtc.RTPCodecParameters{
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2, SDPFmtpLine: "minptime=10;useinbandfec=1", RTCPFeedback: feedback},
+			PayloadType:        OPUS_PAYLOAD_TYPE,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: MIME_TYPE_RED, ClockRate: 48000, Channels: 2, SDPFmtpLine: "111/111", RTCPFeedback: feedback},
+			PayloadType:        RED_PAYLOAD_TYPE,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeG722, ClockRate: 8000},
+			PayloadType:        9,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMU, ClockRate: 8000},
+			PayloadType:        0,
+		},
+		{
+			RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypePCMA, ClockRate: 8000},
+			PayloadType:        8,
+		},
+	} {
+		if err := mediaEngine.RegisterCodec(codec, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, uri := range []string{AUDIO_LEVEL_URI, TRANSPORT_CC_URI} {
+		if err := mediaEngine.RegisterHeaderExtension(webrtc.RTPHeaderExtensionCapability{URI: uri}, webrtc.RTPCodecTypeAudio); err != nil {
+			return nil, err
+		}
+	}
+
+	interceptorRegistry := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(mediaEngine, interceptorRegistry); err != nil {
+		return nil, err
+	}
+
+	// Number outgoing packets so listeners can send transport wide feedback about them.
+	headerExtension, err := twcc.NewHeaderExtensionInterceptor()
+	if err != nil {
+		return nil, err
+	}
+	interceptorRegistry.Add(headerExtension)
+
+	return webrtc.NewAPI(
+		webrtc.WithMediaEngine(mediaEngine),
+		webrtc.WithInterceptorRegistry(interceptorRegistry),
+		webrtc.WithSettingEngine(nucleus.SettingEngine),
+	), nil
+}
+
+// The audio codecs (lower case mime types) the remote side of the peer connection offered
+// or accepted, and the payload type it mapped Opus to.
+func negotiatedCodecs(peerConnection *webrtc.PeerConnection) (map[string]bool, uint8) {
+	remote := peerConnection.RemoteDescription()
+	if remote == nil {
+		return make(map[string]bool), OPUS_PAYLOAD_TYPE
+	}
+	return sdpCodecs(remote.SDP)
+}
+
+// The codecs in a session description's rtpmap lines and the first payload type given to
+// Opus, OPUS_PAYLOAD_TYPE if there is none.
+func sdpCodecs(sdp string) (map[string]bool, uint8) {
+	codecs := make(map[string]bool)
+	opusPayloadType := -1
+
+	// a=rtpmap:<payload type> <encoding name>/<clock rate>[/<channels>]
+	for _, line := range strings.Split(sdp, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "a=rtpmap:") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		codec := "audio/" + strings.ToLower(strings.SplitN(fields[1], "/", 2)[0])
+		codecs[codec] = true
+
+		if codec == strings.ToLower(webrtc.MimeTypeOpus) && opusPayloadType < 0 {
+			if payloadType, err := strconv.ParseUint(strings.TrimPrefix(fields[0], "a=rtpmap:"), 10, 7); err == nil {
+				opusPayloadType = int(payloadType)
+			}
+		}
+	}
+
+	if opusPayloadType < 0 {
+		return codecs, OPUS_PAYLOAD_TYPE
+	}
+	return codecs, uint8(opusPayloadType)
+}
+
+// Record which codecs the client can receive after a remote description is applied.
+func updateNegotiatedCodecs(client *types.Client) {
+	codecs, opusPayloadType := negotiatedCodecs(client.PeerConnection)
+	client.SetReceiveCodecs(codecs, codecs[MIME_TYPE_RED], opusPayloadType)
+}
@@ -0,0 +1,56 @@
+package modules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSDPCodecs(t *testing.T) {
+	tests := []struct {
+		name        string
+		sdp         string
+		codecs      map[string]bool
+		payloadType uint8
+	}{
+		{
+			"browser mapping",
+			"m=audio 9 UDP/TLS/RTP/SAVPF 111 63\r\na=rtpmap:111 opus/48000/2\r\na=rtpmap:63 red/48000/2\r\na=fmtp:63 111/111\r\n",
+			map[string]bool{"audio/opus": true, "audio/red": true},
+			111,
+		},
+		{
+			"opus mapped to 96",
+			"m=audio 9 UDP/TLS/RTP/SAVPF 96 97 0\r\na=rtpmap:96 OPUS/48000/2\r\na=rtpmap:97 red/48000/2\r\na=rtpmap:0 PCMU/8000\r\n",
+			map[string]bool{"audio/opus": true, "audio/red": true, "audio/pcmu": true},
+			96,
+		},
+		{
+			"first opus mapping wins",
+			"a=rtpmap:100 opus/48000/2\na=rtpmap:101 opus/48000/2\n",
+			map[string]bool{"audio/opus": true},
+			100,
+		},
+		{
+			"no opus",
+			"m=audio 9 RTP/AVP 8 0\r\na=rtpmap:8 PCMA/8000\r\na=rtpmap:0 PCMU/8000\r\n",
+			map[string]bool{"audio/pcma": true, "audio/pcmu": true},
+			OPUS_PAYLOAD_TYPE,
+		},
+		{
+			"payload type out of range",
+			"a=rtpmap:200 opus/48000/2\n",
+			map[string]bool{"audio/opus": true},
+			OPUS_PAYLOAD_TYPE,
+		},
+		{"empty", "", map[string]bool{}, OPUS_PAYLOAD_TYPE},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codecs, payloadType := sdpCodecs(test.sdp)
+			if !reflect.DeepEqual(codecs, test.codecs) || payloadType != test.payloadType {
+				t.Errorf("sdpCodecs() = %v, %d, expected %v, %d", codecs, payloadType, test.codecs, test.payloadType)
+			}
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package modules
+
+import (
+	"log"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+// Stop (or resume) routing the client's audio to its listeners. The peer connection is left as is.
+func setMuted(client *types.Client, muted bool) {
+	client.MuteMutex.Lock()
+	client.Muted = muted
+	client.MuteMutex.Unlock()
+
+	log.Printf("Client %s muted: %t\n", client.UUID, muted)
+	broadcastMuteState(client)
+}
+
+// Stop (or resume) routing audio to the client.
+func setDeafened(client *types.Client, deafened bool) {
+	client.MuteMutex.Lock()
+	client.Deafened = deafened
+	client.MuteMutex.Unlock()
+
+	log.Printf("Client %s deafened: %t\n", client.UUID, deafened)
+	broadcastMuteState(client)
+}
+
+// Stop (or resume) routing a single peer's audio to the client.
+func setPeerMuted(client *types.Client, message *types.WebsocketMessage, muted bool) error {
+	peerUUID, err := decodePeer(message)
+	if err != nil {
+		return err
+	}
+
+	client.MuteMutex.Lock()
+	if muted {
+		client.MutedPeers[peerUUID] = true
+	} else {
+		delete(client.MutedPeers, peerUUID)
+	}
+	client.MuteMutex.Unlock()
+
+	log.Printf("Client %s muted peer %s: %t\n", client.UUID, peerUUID, muted)
+	sendMuteState(client, client, true)
+	return nil
+}
+
+// Tell every client (including this one) about the client's mute and deafen state.
+func broadcastMuteState(client *types.Client) {
+	client.Nucleus.Mutex.RLock()
+	defer client.Nucleus.Mutex.RUnlock()
+
+	for uuid, peer := range client.Nucleus.Clients {
+		sendMuteState(peer, client, uuid == client.UUID)
+	}
+}
+
+// Send the recipient the mute state of the client. Per peer mutes are private to the client.
+func sendMuteState(recipient *types.Client, client *types.Client, includePeers bool) {
+	client.MuteMutex.RLock()
+	state := &types.MuteState{
+		UUID:     client.UUID,
+		Muted:    client.Muted,
+		Deafened: client.Deafened,
+	}
+	if includePeers {
+		for peerUUID := range client.MutedPeers {
+			state.MutedPeers = append(state.MutedPeers, peerUUID)
+		}
+	}
+	client.MuteMutex.RUnlock()
+
+	recipient.WriteChan <- &types.WebsocketMessage{
+		Event:   "peer_mute_state",
+		Payload: state,
+	}
+}
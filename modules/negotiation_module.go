@@ -0,0 +1,140 @@
+package modules
+
+import (
+	"log"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+// Offer the client the peer connection's changes. While an offer is outstanding the
+// renegotiation is queued and replayed once signaling is stable again. The offer is sent
+// after the negotiation mutex is released so a stalled writer can't hold up signaling.
+func negotiate(client *types.Client, peerConnection *webrtc.PeerConnection) {
+	// HTTP sessions have no way to receive an offer.
+	if client.HTTPSession != nil {
+		return
+	}
+
+	offer := createOffer(client, peerConnection)
+	if offer == nil {
+		return
+	}
+
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "wrtc_renegotiation_needed",
+		Payload: offer,
+	}
+}
+
+// Set a new local offer, nil if the renegotiation was queued or failed.
+func createOffer(client *types.Client, peerConnection *webrtc.PeerConnection) *webrtc.SessionDescription {
+	client.Negotiation.Mutex.Lock()
+	defer client.Negotiation.Mutex.Unlock()
+
+	if peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		log.Printf("Client %s renegotiation queued behind an outstanding offer\n", client.UUID)
+		client.Negotiation.Pending = true
+		return nil
+	}
+	client.Negotiation.Pending = false
+
+	options := &webrtc.OfferOptions{ICERestart: client.Negotiation.RestartICE}
+	client.Negotiation.RestartICE = false
+
+	offer, err := peerConnection.CreateOffer(options)
+	if err != nil {
+		log.Printf("Error renegotiating offer: %s", err)
+		return nil
+	}
+
+	if err = peerConnection.SetLocalDescription(offer); err != nil {
+		log.Printf("Error setting local description: %s", err)
+		return nil
+	}
+	return &offer
+}
+
+// Wait out the negotiation window so that tracks added or removed together, when several
+// peers come into range at once, reach the client in a single offer. Pion doesn't ask
+// again until the offer is made, so later changes in the window ride along.
+func batchNegotiation(client *types.Client, peerConnection *webrtc.PeerConnection) {
+	window := client.Nucleus.Config.NegotiationWindow
+	if window <= 0 {
+		negotiate(client, peerConnection)
+		return
+	}
+
+	client.Negotiation.Mutex.Lock()
+	defer client.Negotiation.Mutex.Unlock()
+
+	if client.Negotiation.Batch != nil {
+		return
+	}
+
+	client.Negotiation.Batch = time.AfterFunc(window, func() {
+		client.Negotiation.Mutex.Lock()
+		client.Negotiation.Batch = nil
+		client.Negotiation.Mutex.Unlock()
+
+		// The peer connection was replaced or closed during the window.
+		if currentPeerConnection(client) != peerConnection {
+			return
+		}
+		negotiate(client, peerConnection)
+	})
+}
+
+// Run a renegotiation that was queued while an offer was outstanding.
+func replayNegotiation(client *types.Client, peerConnection *webrtc.PeerConnection) {
+	client.Negotiation.Mutex.Lock()
+	pending := client.Negotiation.Pending
+	client.Negotiation.Mutex.Unlock()
+
+	if pending && peerConnection.SignalingState() == webrtc.SignalingStateStable {
+		negotiate(client, peerConnection)
+	}
+}
+
+// Answer an offer from the client. When it collides with the server's own offer a polite
+// server rolls its offer back and renegotiates afterwards, an impolite one ignores the
+// client's offer and leaves the client to roll back.
+func answerOffer(client *types.Client, peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription) error {
+	answer, err := acceptOffer(client, peerConnection, offer)
+	if err != nil {
+		return err
+	}
+
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "wrtc_answer",
+		Payload: answer,
+	}
+	return nil
+}
+
+// Apply the client's offer and set the local answer to it under the negotiation mutex.
+func acceptOffer(client *types.Client, peerConnection *webrtc.PeerConnection, offer webrtc.SessionDescription) (*webrtc.SessionDescription, error) {
+	client.Negotiation.Mutex.Lock()
+	defer client.Negotiation.Mutex.Unlock()
+
+	if peerConnection.SignalingState() != webrtc.SignalingStateStable {
+		if !client.Nucleus.Config.PoliteServer {
+			return nil, types.NewProtocolError(types.ERROR_GLARE, "offer collided with the server's, roll back and answer it")
+		}
+
+		if err := peerConnection.SetLocalDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeRollback}); err != nil {
+			return nil, types.NewProtocolError(types.ERROR_NEGOTIATION_FAILED, "rolling back local offer: %s", err)
+		}
+
+		// The rolled back changes still need to reach the client.
+		client.Negotiation.Pending = true
+	}
+
+	if err := peerConnection.SetRemoteDescription(offer); err != nil {
+		return nil, types.NewProtocolError(types.ERROR_NEGOTIATION_FAILED, "setting remote description: %s", err)
+	}
+	updateNegotiatedCodecs(client)
+
+	return createAnswer(peerConnection)
+}
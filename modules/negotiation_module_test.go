@@ -0,0 +1,51 @@
+package modules
+
+import (
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestNegotiateReleasesMutexBeforeSending(t *testing.T) {
+	peerConnection, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peerConnection.Close()
+	if _, err := peerConnection.AddTransceiverFromKind(webrtc.RTPCodecTypeAudio); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing reads the channel, as when the writer is stalled.
+	client := &types.Client{WriteChan: make(chan *types.WebsocketMessage)}
+	go negotiate(client, peerConnection)
+
+	// The offer is set before it is sent.
+	deadline := time.Now().Add(5 * time.Second)
+	for peerConnection.SignalingState() != webrtc.SignalingStateHaveLocalOffer {
+		if time.Now().After(deadline) {
+			t.Fatal("no local offer was set")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	locked := make(chan bool)
+	go func() {
+		client.Negotiation.Mutex.Lock()
+		client.Negotiation.Mutex.Unlock()
+		locked <- true
+	}()
+
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("negotiation mutex held while sending the offer")
+	}
+
+	message := <-client.WriteChan
+	if message.Event != "wrtc_renegotiation_needed" {
+		t.Errorf("sent %s, expected wrtc_renegotiation_needed", message.Event)
+	}
+}
@@ -0,0 +1,269 @@
+package modules
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media/oggreader"
+)
+
+const (
+	oggPageHeaderTypeContinuation = 0x00
+	oggPageHeaderTypeBeginning    = 0x02
+	oggPageHeaderTypeEnd          = 0x04
+
+	// Samples the decoder should discard from the start of each stream, matches oggwriter.
+	oggOpusPreSkip = 3840
+)
+
+var (
+	oggChecksumTable = generateOggChecksumTable()
+)
+
+// An Ogg Opus file to be multiplexed, the serial number of its logical stream in the
+// output and how far into the output it starts.
+type oggMuxInput struct {
+	Path   string
+	Serial uint32
+	Offset time.Duration
+}
+
+// A logical stream being copied into a multiplexed file.
+type oggMuxStream struct {
+	file      *os.File
+	reader    *oggreader.OggReader
+	channels  uint8
+	serial    uint32
+	offset    uint64
+	pageIndex uint32
+
+	// The next page to be written, nil once the stream is exhausted.
+	payload  []byte
+	granule  uint64
+	lastPage bool
+}
+
+// Combine Ogg Opus files (as written by oggwriter, one packet per page) into a single file
+// holding each input as its own logical stream. Each stream's granule positions are shifted
+// by the input's offset so that all of the streams line up on a shared timeline.
+func muxOggOpus(out string, inputs []*oggMuxInput) error {
+	streams := make([]*oggMuxStream, 0)
+	defer func() {
+		for _, stream := range streams {
+			stream.file.Close()
+		}
+	}()
+
+	for _, input := range inputs {
+		file, err := os.Open(input.Path)
+		if err != nil {
+			return err
+		}
+
+		reader, header, err := oggreader.NewWith(file)
+		if err != nil {
+			file.Close()
+			return err
+		}
+
+		stream := &oggMuxStream{
+			file:     file,
+			reader:   reader,
+			channels: header.Channels,
+			serial:   input.Serial,
+			offset:   uint64(input.Offset.Seconds() * 48000),
+		}
+		streams = append(streams, stream)
+
+		// Skip the comment header, a new one is written for the stream.
+		if err := stream.advance(); err != nil {
+			return err
+		}
+		if stream.payload != nil && bytes.HasPrefix(stream.payload, []byte("OpusTags")) {
+			if err := stream.advance(); err != nil {
+				return err
+			}
+		}
+	}
+
+	outFile, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	// All beginning of stream pages come first, then the comment headers, then the audio.
+	for _, stream := range streams {
+		idHeader := make([]byte, 19)
+		copy(idHeader, "OpusHead")
+		idHeader[8] = 1
+		idHeader[9] = stream.channels
+		binary.LittleEndian.PutUint16(idHeader[10:], oggOpusPreSkip)
+		binary.LittleEndian.PutUint32(idHeader[12:], 48000)
+
+		if err := stream.writePage(outFile, idHeader, oggPageHeaderTypeBeginning, 0); err != nil {
+			return err
+		}
+	}
+
+	for _, stream := range streams {
+		commentHeader := make([]byte, 20)
+		copy(commentHeader, "OpusTags")
+		binary.LittleEndian.PutUint32(commentHeader[8:], 4)
+		copy(commentHeader[12:], "pion")
+
+		if err := stream.writePage(outFile, commentHeader, oggPageHeaderTypeContinuation, 0); err != nil {
+			return err
+		}
+	}
+
+	// Interleave the audio pages in timeline order.
+	for {
+		var earliest *oggMuxStream
+		for _, stream := range streams {
+			if stream.payload == nil {
+				continue
+			}
+			if earliest == nil || stream.offset+stream.granule < earliest.offset+earliest.granule {
+				earliest = stream
+			}
+		}
+
+		if earliest == nil {
+			return nil
+		}
+
+		payload := earliest.payload
+		granule := earliest.offset + earliest.granule
+		if err := earliest.advance(); err != nil {
+			return err
+		}
+
+		headerType := byte(oggPageHeaderTypeContinuation)
+		if earliest.payload == nil {
+			headerType = oggPageHeaderTypeEnd
+		}
+
+		if err := earliest.writePage(outFile, payload, headerType, granule); err != nil {
+			return err
+		}
+	}
+}
+
+// Read the stream's next page. The payload is nil at the end of the stream.
+func (s *oggMuxStream) advance() error {
+	payload, header, err := s.reader.ParseNextPage()
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		s.payload = nil
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	s.payload = payload
+	s.granule = header.GranulePosition
+	return nil
+}
+
+func (s *oggMuxStream) writePage(out io.Writer, payload []byte, headerType byte, granule uint64) error {
+	_, err := out.Write(createOggPage(payload, headerType, granule, s.serial, s.pageIndex))
+	s.pageIndex++
+	return err
+}
+
+// Build an Ogg page holding a single packet.
+func createOggPage(payload []byte, headerType byte, granule uint64, serial uint32, pageIndex uint32) []byte {
+	// Lacing values, a packet ends with a segment shorter than 255 bytes.
+	segments := make([]byte, 0, len(payload)/255+1)
+	for remaining := len(payload); ; remaining -= 255 {
+		if remaining < 255 {
+			segments = append(segments, byte(remaining))
+			break
+		}
+		segments = append(segments, 255)
+	}
+
+	page := make([]byte, 27, 27+len(segments)+len(payload))
+	copy(page, "OggS")
+	page[5] = headerType
+	binary.LittleEndian.PutUint64(page[6:], granule)
+	binary.LittleEndian.PutUint32(page[14:], serial)
+	binary.LittleEndian.PutUint32(page[18:], pageIndex)
+	page[26] = byte(len(segments))
+	page = append(page, segments...)
+	page = append(page, payload...)
+
+	var checksum uint32
+	for index := range page {
+		checksum = (checksum << 8) ^ oggChecksumTable[byte(checksum>>24)^page[index]]
+	}
+	binary.LittleEndian.PutUint32(page[22:], checksum)
+
+	return page
+}
+
+func generateOggChecksumTable() *[256]uint32 {
+	var table [256]uint32
+	const poly = 0x04c11db7
+
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if (r & 0x80000000) != 0 {
+				r = (r << 1) ^ poly
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return &table
+}
+
+// Read each audio packet of an Ogg Opus file along with how long it plays for. Files are
+// expected to hold one packet per page (e.g. ffmpeg -page_duration 20000). Reading stops
+// early when handle returns false.
+func readOggOpus(path string, handle func(packet []byte, duration time.Duration) bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, _, err := oggreader.NewWith(file)
+	if err != nil {
+		return err
+	}
+
+	var lastGranule uint64
+	for {
+		payload, header, err := reader.ParseNextPage()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(payload, []byte("OpusTags")) {
+			continue
+		}
+
+		// Granule positions count 48kHz samples. Fall back to a 20ms frame when the
+		// position doesn't give a sensible duration (e.g. the first page's pre-skip).
+		duration := 20 * time.Millisecond
+		if header.GranulePosition > lastGranule {
+			samples := header.GranulePosition - lastGranule
+			if samples <= 48000*120/1000 {
+				duration = time.Duration(samples) * time.Second / 48000
+			}
+		}
+		lastGranule = header.GranulePosition
+
+		if !handle(payload, duration) {
+			return nil
+		}
+	}
+}
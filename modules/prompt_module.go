@@ -0,0 +1,98 @@
+package modules
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+var (
+	// Prompts loaded from the prompts directory, each read from <name>.ogg
+	PROMPT_NAMES = []string{"joined", "left", "floor_granted"}
+)
+
+// Load the audio prompts from Ogg Opus files. Missing prompts are skipped.
+func LoadPrompts(nucleus *types.Nucleus, dir string) error {
+	for _, name := range PROMPT_NAMES {
+		path := filepath.Join(dir, name+".ogg")
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			log.Printf("No %s prompt at %s\n", name, path)
+			continue
+		}
+
+		samples := make([]media.Sample, 0)
+		err := readOggOpus(path, func(packet []byte, duration time.Duration) bool {
+			samples = append(samples, media.Sample{Data: packet, Duration: duration})
+			return true
+		})
+		if err != nil {
+			return err
+		}
+
+		nucleus.Prompts[name] = samples
+		log.Printf("Loaded %s prompt (%d frames)\n", name, len(samples))
+	}
+	return nil
+}
+
+// Give the client a dedicated track for prompts. Only added when prompts are loaded, nil
+// otherwise.
+func addAnnouncementTrack(client *types.Client, peerConnection *webrtc.PeerConnection) *webrtc.TrackLocalStaticSample {
+	if len(client.Nucleus.Prompts) == 0 {
+		return nil
+	}
+
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "announcements", "hiwave_announcements")
+	if err != nil {
+		log.Printf("Error creating announcement track: %s", err)
+		return nil
+	}
+
+	transceiver, err := peerConnection.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		log.Printf("Error adding announcement track: %s", err)
+		return nil
+	}
+	go drainSenderRTCP(client, transceiver.Sender())
+
+	return track
+}
+
+// Play a prompt to the client on its announcement track. Prompts queue behind each other.
+func playPrompt(client *types.Client, name string) {
+	samples := client.Nucleus.Prompts[name]
+	track := currentAnnouncements(client)
+	if len(samples) == 0 || track == nil {
+		return
+	}
+
+	go func() {
+		client.AnnounceMutex.Lock()
+		defer client.AnnounceMutex.Unlock()
+
+		next := time.Now()
+		for _, sample := range samples {
+			if err := track.WriteSample(sample); err != nil {
+				log.Printf("Error writing %s prompt: %s", name, err)
+				return
+			}
+
+			// Pace the frames in real time.
+			next = next.Add(sample.Duration)
+			time.Sleep(time.Until(next))
+		}
+	}()
+}
+
+// The client's announcement track, nil if it has none or its peer connection is gone.
+func currentAnnouncements(client *types.Client) *webrtc.TrackLocalStaticSample {
+	client.PCMutex.RLock()
+	defer client.PCMutex.RUnlock()
+	return client.Announcements
+}
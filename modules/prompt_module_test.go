@@ -0,0 +1,45 @@
+package modules
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+func TestPlayPromptDuringTeardown(t *testing.T) {
+	track, err := webrtc.NewTrackLocalStaticSample(webrtc.RTPCodecCapability{MimeType: "audio/opus"}, "announcements", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &types.Client{
+		Nucleus: &types.Nucleus{Prompts: map[string][]media.Sample{
+			"joined": {{Data: []byte{0xf8, 0xff, 0xfe}, Duration: time.Millisecond}},
+		}},
+		Announcements: track,
+	}
+
+	// Tear down the announcement track the way handleDisconnect does while prompts play.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		client.PCMutex.Lock()
+		client.Announcements = nil
+		client.PCMutex.Unlock()
+	}()
+
+	for i := 0; i < 100; i++ {
+		playPrompt(client, "joined")
+	}
+	wg.Wait()
+
+	if currentAnnouncements(client) != nil {
+		t.Errorf("announcement track survived teardown")
+	}
+	playPrompt(client, "joined")
+}
@@ -0,0 +1,112 @@
+package modules
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+)
+
+// Settle on the protocol version the client asked for, or the newest the server speaks
+// if the client is ahead of it.
+func handleHello(client *types.Client, message *types.WebsocketMessage) error {
+	hello := &types.HelloPayload{}
+	if err := decodePayload(message, hello); err != nil {
+		return err
+	}
+
+	if hello.Version < types.MIN_PROTOCOL_VERSION {
+		return types.NewProtocolError(types.ERROR_UNSUPPORTED_VERSION, "version %d is older than %d", hello.Version, types.MIN_PROTOCOL_VERSION)
+	}
+
+	version := hello.Version
+	if version > types.PROTOCOL_VERSION {
+		version = types.PROTOCOL_VERSION
+	}
+
+	client.ProtocolMutex.Lock()
+	client.ProtocolVersion = version
+	client.ProtocolMutex.Unlock()
+
+	log.Printf("Client %s speaks protocol version %d\n", client.UUID, version)
+
+	client.WriteChan <- &types.WebsocketMessage{
+		Event: "hello",
+		Payload: &types.HelloPayload{
+			Version: version,
+			UUID:    client.UUID,
+			Polite:  client.Nucleus.Config.PoliteServer,
+		},
+	}
+	return nil
+}
+
+// Tell the client an event it sent failed, echoing the message's id. Errors that aren't
+// protocol errors are internal.
+func sendError(client *types.Client, message *types.WebsocketMessage, err error) {
+	log.Printf("Client %s event %q failed: %s\n", client.UUID, message.Event, err)
+
+	payload := &types.ErrorPayload{
+		Code:    types.ERROR_INTERNAL,
+		Message: err.Error(),
+		Event:   message.Event,
+	}
+
+	var protocolErr *types.ProtocolError
+	if errors.As(err, &protocolErr) {
+		payload.Code = protocolErr.Code
+		payload.Message = protocolErr.Message
+	}
+
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "error",
+		Payload: payload,
+		ID:      message.ID,
+	}
+}
+
+// Tell the client an event it sent with an id has been applied.
+func sendAck(client *types.Client, message *types.WebsocketMessage) {
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "ack",
+		Payload: &types.AckPayload{Event: message.Event},
+		ID:      message.ID,
+	}
+}
+
+// Decode the message's data into its payload struct.
+func decodePayload(message *types.WebsocketMessage, payload interface{}) error {
+	if err := json.Unmarshal([]byte(message.Data), payload); err != nil {
+		return types.NewProtocolError(types.ERROR_INVALID_PAYLOAD, "%s", err)
+	}
+	return nil
+}
+
+// The peer a message is about, sent either on its own or as a PeerPayload.
+func decodePeer(message *types.WebsocketMessage) (uuid.UUID, error) {
+	if peerUUID, err := uuid.Parse(message.Data); err == nil {
+		return peerUUID, nil
+	}
+
+	peer := &types.PeerPayload{}
+	if err := decodePayload(message, peer); err != nil {
+		return uuid.Nil, err
+	}
+	return peer.UUID, nil
+}
+
+// The avatar a set_current_avatar message carries, sent either on its own or as an AvatarPayload.
+func decodeAvatar(message *types.WebsocketMessage) (string, error) {
+	if !strings.HasPrefix(strings.TrimSpace(message.Data), "{") {
+		return message.Data, nil
+	}
+
+	avatar := &types.AvatarPayload{}
+	if err := decodePayload(message, avatar); err != nil {
+		return "", err
+	}
+	return avatar.Avatar, nil
+}
@@ -0,0 +1,217 @@
+package modules
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+var (
+	// How often the recording directory is checked for recordings past retention.
+	RECORDING_PRUNE_INTERVAL = time.Hour
+)
+
+// Start writing the client's inbound audio to an Ogg Opus file named by session and client uuid.
+func StartRecording(client *types.Client) (*types.RecordingState, error) {
+	client.RecMutex.Lock()
+
+	if client.Recording != nil {
+		client.RecMutex.Unlock()
+		return nil, types.NewProtocolError(types.ERROR_INVALID_STATE, "client is already being recorded")
+	}
+
+	dir := client.Nucleus.Config.RecordingDir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		client.RecMutex.Unlock()
+		return nil, err
+	}
+
+	started := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("%s_%s_%d.ogg", client.Nucleus.SessionID, client.UUID, started.Unix()))
+
+	writer, err := oggwriter.New(path, 48000, 2)
+	if err != nil {
+		client.RecMutex.Unlock()
+		return nil, err
+	}
+
+	client.Recording = &types.Recording{
+		Writer:  writer,
+		Path:    path,
+		Started: started,
+	}
+	client.RecMutex.Unlock()
+
+	log.Printf("Started recording client %s to %s\n", client.UUID, path)
+
+	state := &types.RecordingState{
+		UUID:    client.UUID,
+		File:    filepath.Base(path),
+		Started: started,
+	}
+	sendRecordingState(client, "recording_started", state)
+
+	return state, nil
+}
+
+// Stop recording the client and tell them about it.
+func StopRecording(client *types.Client) (*types.RecordingState, error) {
+	state := finishRecording(client)
+	if state == nil {
+		return nil, types.NewProtocolError(types.ERROR_INVALID_STATE, "client is not being recorded")
+	}
+
+	sendRecordingState(client, "recording_stopped", state)
+	return state, nil
+}
+
+// Close the client's recording if there is one. Safe to call once the client's writer has stopped.
+func finishRecording(client *types.Client) *types.RecordingState {
+	client.RecMutex.Lock()
+	defer client.RecMutex.Unlock()
+
+	recording := client.Recording
+	if recording == nil {
+		return nil
+	}
+	client.Recording = nil
+
+	if err := recording.Writer.Close(); err != nil {
+		log.Printf("Error closing recording %s: %s", recording.Path, err)
+	}
+
+	log.Printf("Stopped recording client %s to %s\n", client.UUID, recording.Path)
+
+	stopped := time.Now()
+	return &types.RecordingState{
+		UUID:    client.UUID,
+		File:    filepath.Base(recording.Path),
+		Started: recording.Started,
+		Stopped: &stopped,
+	}
+}
+
+// Write an inbound RTP packet to the client's recording if it is being recorded.
+func recordPacket(client *types.Client, packet []byte) {
+	client.RecMutex.Lock()
+	defer client.RecMutex.Unlock()
+
+	if client.Recording == nil {
+		return
+	}
+
+	rtpPacket := &rtp.Packet{}
+	if err := rtpPacket.Unmarshal(packet); err != nil {
+		log.Printf("Error unmarshaling packet for recording: %s", err)
+		return
+	}
+
+	if !opusForRecording(client, rtpPacket) {
+		return
+	}
+
+	if err := client.Recording.Writer.WriteRTP(rtpPacket); err != nil {
+		log.Printf("Error writing recording %s: %s", client.Recording.Path, err)
+	}
+}
+
+// Strip a packet down to plain Opus for writing to Ogg. Only Opus (and Opus RED) can be recorded.
+func opusForRecording(client *types.Client, packet *rtp.Packet) bool {
+	_, codec := client.InboundStream()
+	switch strings.ToLower(codec) {
+	case "", strings.ToLower(webrtc.MimeTypeOpus):
+		return true
+	case MIME_TYPE_RED:
+		primary, err := redPrimary(packet.Payload)
+		if err != nil {
+			return false
+		}
+		packet.Payload = primary
+		return true
+	}
+	return false
+}
+
+func sendRecordingState(client *types.Client, event string, state *types.RecordingState) {
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   event,
+		Payload: state,
+	}
+}
+
+// Delete recordings older than the configured retention. Runs forever.
+func PruneRecordings(nucleus *types.Nucleus) {
+	ticker := time.NewTicker(RECORDING_PRUNE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		pruneRecordings(nucleus.Config.RecordingDir, nucleus.Config.RecordingRetention)
+		<-ticker.C
+	}
+}
+
+// Walk the recording directory and delete what is past retention. A conversation's
+// directory (the one holding its sidecar) is deleted as a unit once nothing in it has
+// been written to within the retention, so that its audio and sidecar go together.
+func pruneRecordings(root string, retention time.Duration) {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			if _, err := os.Stat(filepath.Join(path, conversationSidecar)); err != nil {
+				return nil
+			}
+
+			if newest, err := newestModTime(path); err != nil {
+				log.Printf("Error reading conversation %s: %s", path, err)
+			} else if time.Since(newest) > retention {
+				if err := os.RemoveAll(path); err != nil {
+					log.Printf("Error removing conversation %s: %s", path, err)
+				} else {
+					log.Printf("Removed conversation %s past retention\n", path)
+				}
+			}
+			return filepath.SkipDir
+		}
+
+		if strings.HasSuffix(info.Name(), ".ogg") && time.Since(info.ModTime()) > retention {
+			if err := os.Remove(path); err != nil {
+				log.Printf("Error removing recording %s: %s", path, err)
+			} else {
+				log.Printf("Removed recording %s past retention\n", path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading recording directory: %s", err)
+	}
+}
+
+// The latest modification time of the files in the directory.
+func newestModTime(dir string) (time.Time, error) {
+	var newest time.Time
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
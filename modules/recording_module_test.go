@@ -0,0 +1,69 @@
+package modules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPruneRecordings(t *testing.T) {
+	root, err := ioutil.TempDir("", "recordings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	old := time.Now().Add(-2 * time.Hour)
+	write := func(path string, modified time.Time) {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte("OggS"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("old.ogg", old)
+	write("new.ogg", time.Now())
+	write("notes.txt", old)
+	write("2021/old.ogg", old)
+	write("2021/old_conversation/conversation.ogg", old)
+	write("2021/old_conversation/conversation.json", old)
+	write("2021/new_conversation/conversation.ogg", old)
+	write("2021/new_conversation/conversation.json", time.Now())
+	write("2021/new_conversation/participant.ogg", old)
+
+	pruneRecordings(root, time.Hour)
+
+	tests := []struct {
+		path string
+		kept bool
+	}{
+		{"old.ogg", false},
+		{"new.ogg", true},
+		{"notes.txt", true},
+		{"2021/old.ogg", false},
+		{"2021/old_conversation", false},
+		{"2021/new_conversation/conversation.ogg", true},
+		{"2021/new_conversation/conversation.json", true},
+		{"2021/new_conversation/participant.ogg", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			_, err := os.Stat(filepath.Join(root, test.path))
+			if kept := err == nil; kept != test.kept {
+				t.Errorf("kept %t, expected %t", kept, test.kept)
+			}
+		})
+	}
+
+	// A missing recording directory is nothing to prune.
+	pruneRecordings(filepath.Join(root, "missing"), time.Hour)
+}
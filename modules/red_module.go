@@ -0,0 +1,229 @@
+package modules
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/rtp"
+)
+
+var (
+	errShortRED = errors.New("RED payload is too short")
+)
+
+// The packets a speaker's audio is forwarded as, built at most once per inbound packet.
+// Listeners that negotiated RED get it (with redundancy when their link is lossy), the rest
+// get plain Opus.
+type redForwarder struct {
+	// The previous primary Opus payload and its timestamp, used as redundancy.
+	previous          []byte
+	previousTimestamp uint32
+
+	// The current inbound packet and the variants built from it.
+	packet     *rtp.Packet
+	raw        []byte
+	variants   map[string][]byte
+	inboundRED bool
+	primary    []byte
+
+	// The payload type the speaker mapped Opus to, which the blocks of its RED payloads carry.
+	opusPayloadType uint8
+}
+
+func newREDForwarder() *redForwarder {
+	return &redForwarder{}
+}
+
+// Start forwarding a new inbound packet.
+func (f *redForwarder) next(client *types.Client, raw []byte) {
+	f.raw = raw
+	f.variants = make(map[string][]byte)
+	_, codec := client.InboundStream()
+	f.inboundRED = codec == MIME_TYPE_RED
+	f.opusPayloadType = opusPayloadType(client)
+
+	// Remember the last packet's primary payload before replacing it.
+	if f.primary != nil && f.packet != nil {
+		f.previous = f.primary
+		f.previousTimestamp = f.packet.Timestamp
+	}
+
+	f.packet = &rtp.Packet{}
+	f.primary = nil
+	if err := f.packet.Unmarshal(raw); err != nil {
+		log.Printf("Error unmarshaling packet for RED: %s", err)
+		f.packet = nil
+		return
+	}
+
+	f.primary = f.packet.Payload
+	if f.inboundRED {
+		primary, err := redPrimary(f.packet.Payload)
+		if err != nil {
+			log.Printf("Error reading RED payload: %s", err)
+			f.primary = nil
+			return
+		}
+		f.primary = primary
+	}
+}
+
+// The packet to send down a listener's track.
+func (f *redForwarder) packetFor(client *types.Client, bundle *types.AudioBundle) []byte {
+	if f.packet == nil {
+		return f.raw
+	}
+
+	if bundle.Transcode != nil {
+		kind := "transcode_" + bundle.Track.Codec().MimeType
+		if built := f.variants[kind]; built != nil {
+			return built
+		}
+		return f.variant(kind, bundle.Transcode(f.packet.Payload))
+	}
+
+	if f.primary == nil {
+		return f.raw
+	}
+
+	if bundle.Track.Codec().MimeType != MIME_TYPE_RED {
+		if !f.inboundRED {
+			return f.raw
+		}
+		return f.variant("opus", f.primary)
+	}
+
+	// RED blocks carry the Opus payload type of whoever receives them.
+	listenerPayloadType := opusPayloadType(bundle.Listener)
+
+	// Redundancy from the speaker is passed through, relabelled if the listener maps Opus elsewhere.
+	if f.inboundRED {
+		if listenerPayloadType == f.opusPayloadType {
+			return f.raw
+		}
+
+		kind := fmt.Sprintf("red_relabelled_%d", listenerPayloadType)
+		if built := f.variants[kind]; built != nil {
+			return built
+		}
+		payload, err := relabelRED(f.packet.Payload, listenerPayloadType)
+		if err != nil {
+			log.Printf("Error relabelling RED payload: %s", err)
+			return f.raw
+		}
+		return f.variant(kind, payload)
+	}
+
+	if f.previous != nil && listenerLoss(bundle) >= client.Nucleus.Config.REDLossThreshold {
+		kind := fmt.Sprintf("red_redundant_%d", listenerPayloadType)
+		return f.variant(kind, encodeRED(f.primary, f.previous, f.packet.Timestamp-f.previousTimestamp, listenerPayloadType))
+	}
+	return f.variant(fmt.Sprintf("red_%d", listenerPayloadType), encodeRED(f.primary, nil, 0, listenerPayloadType))
+}
+
+// The payload type the client mapped Opus to, OPUS_PAYLOAD_TYPE until it has negotiated.
+func opusPayloadType(client *types.Client) uint8 {
+	if payloadType := client.OpusPayloadType(); payloadType != 0 {
+		return payloadType
+	}
+	return OPUS_PAYLOAD_TYPE
+}
+
+// Marshal the inbound packet with a different payload, once per kind.
+func (f *redForwarder) variant(kind string, payload []byte) []byte {
+	if built := f.variants[kind]; built != nil {
+		return built
+	}
+
+	packet := &rtp.Packet{Header: f.packet.Header, Payload: payload}
+	built, err := packet.Marshal()
+	if err != nil {
+		log.Printf("Error marshaling %s packet: %s", kind, err)
+		return f.raw
+	}
+
+	f.variants[kind] = built
+	return built
+}
+
+func listenerLoss(bundle *types.AudioBundle) float64 {
+	bundle.StatsMutex.RLock()
+	defer bundle.StatsMutex.RUnlock()
+
+	if bundle.Stats == nil {
+		return 0
+	}
+	return bundle.Stats.LossPercent
+}
+
+// The primary (most recent) block of a RED payload, RFC 2198.
+func redPrimary(payload []byte) ([]byte, error) {
+	offset := 0
+	redundantLength := 0
+
+	// Redundant block headers have the F bit set, the primary block header is a single byte.
+	for {
+		if offset >= len(payload) {
+			return nil, errShortRED
+		}
+
+		if payload[offset]&0x80 == 0 {
+			offset++
+			break
+		}
+
+		if offset+4 > len(payload) {
+			return nil, errShortRED
+		}
+		redundantLength += int(payload[offset+2]&0x03)<<8 | int(payload[offset+3])
+		offset += 4
+	}
+
+	if offset+redundantLength > len(payload) {
+		return nil, errShortRED
+	}
+	return payload[offset+redundantLength:], nil
+}
+
+// Copy a RED payload with every block labelled with the payload type.
+func relabelRED(payload []byte, payloadType uint8) ([]byte, error) {
+	// Validates the block headers.
+	if _, err := redPrimary(payload); err != nil {
+		return nil, err
+	}
+
+	relabelled := make([]byte, len(payload))
+	copy(relabelled, payload)
+	for offset := 0; ; offset += 4 {
+		relabelled[offset] = relabelled[offset]&0x80 | payloadType&0x7f
+		if relabelled[offset]&0x80 == 0 {
+			return relabelled, nil
+		}
+	}
+}
+
+// Build a RED payload from a primary Opus payload and, optionally, the previous one as
+// redundancy. Both blocks are labelled with the receiver's Opus payload type.
+func encodeRED(primary []byte, redundant []byte, timestampOffset uint32, payloadType uint8) []byte {
+	payloadType &= 0x7f
+
+	// The offset and length fields are 14 and 10 bits wide.
+	if redundant == nil || timestampOffset >= 1<<14 || len(redundant) >= 1<<10 {
+		payload := make([]byte, 0, 1+len(primary))
+		payload = append(payload, payloadType)
+		return append(payload, primary...)
+	}
+
+	payload := make([]byte, 0, 5+len(redundant)+len(primary))
+	payload = append(payload,
+		0x80|payloadType,
+		byte(timestampOffset>>6),
+		byte(timestampOffset&0x3f)<<2|byte(len(redundant)>>8),
+		byte(len(redundant)),
+		payloadType,
+	)
+	payload = append(payload, redundant...)
+	return append(payload, primary...)
+}
@@ -0,0 +1,165 @@
+package modules
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/rtp"
+	"github.com/pion/webrtc/v3"
+)
+
+func TestEncodeRED(t *testing.T) {
+	primary := []byte{1, 2, 3}
+	redundant := []byte{4, 5}
+	long := make([]byte, 1<<10)
+
+	tests := []struct {
+		name            string
+		redundant       []byte
+		timestampOffset uint32
+		payloadType     uint8
+		expected        []byte
+	}{
+		{"primary only", nil, 0, 111, []byte{111, 1, 2, 3}},
+		{"with redundancy", redundant, 960, 111, []byte{0x80 | 111, 0x0f, 0x00, 0x02, 111, 4, 5, 1, 2, 3}},
+		{"listener maps opus to 96", redundant, 960, 96, []byte{0x80 | 96, 0x0f, 0x00, 0x02, 96, 4, 5, 1, 2, 3}},
+		{"primary only mapped to 96", nil, 0, 96, []byte{96, 1, 2, 3}},
+		{"largest timestamp offset", redundant, 1<<14 - 1, 111, []byte{0x80 | 111, 0xff, 0xfc, 0x02, 111, 4, 5, 1, 2, 3}},
+		{"timestamp offset too wide", redundant, 1 << 14, 111, []byte{111, 1, 2, 3}},
+		{"redundancy too long", long, 960, 111, []byte{111, 1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if payload := encodeRED(primary, test.redundant, test.timestampOffset, test.payloadType); !bytes.Equal(payload, test.expected) {
+				t.Errorf("encodeRED() = %x, expected %x", payload, test.expected)
+			}
+		})
+	}
+}
+
+func TestREDPrimary(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  []byte
+		expected []byte
+		err      error
+	}{
+		{"primary only", []byte{OPUS_PAYLOAD_TYPE, 1, 2, 3}, []byte{1, 2, 3}, nil},
+		{"one redundant block", encodeRED([]byte{1, 2, 3}, []byte{4, 5}, 960, OPUS_PAYLOAD_TYPE), []byte{1, 2, 3}, nil},
+		{"two redundant blocks", []byte{
+			0x80 | OPUS_PAYLOAD_TYPE, 0x1e, 0x00, 0x01,
+			0x80 | OPUS_PAYLOAD_TYPE, 0x0f, 0x00, 0x02,
+			OPUS_PAYLOAD_TYPE,
+			6, 4, 5, 1, 2, 3,
+		}, []byte{1, 2, 3}, nil},
+		{"empty primary", []byte{OPUS_PAYLOAD_TYPE}, []byte{}, nil},
+		{"empty", []byte{}, nil, errShortRED},
+		{"truncated block header", []byte{0x80 | OPUS_PAYLOAD_TYPE, 0x0f, 0x00}, nil, errShortRED},
+		{"missing primary header", []byte{0x80 | OPUS_PAYLOAD_TYPE, 0x0f, 0x00, 0x02}, nil, errShortRED},
+		{"truncated redundant block", []byte{0x80 | OPUS_PAYLOAD_TYPE, 0x0f, 0x00, 0x04, OPUS_PAYLOAD_TYPE, 4, 5}, nil, errShortRED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			primary, err := redPrimary(test.payload)
+			if err != test.err || !bytes.Equal(primary, test.expected) {
+				t.Errorf("redPrimary() = %x, %v, expected %x, %v", primary, err, test.expected, test.err)
+			}
+		})
+	}
+}
+
+func TestRelabelRED(t *testing.T) {
+	tests := []struct {
+		name        string
+		payload     []byte
+		payloadType uint8
+		expected    []byte
+		err         error
+	}{
+		{"primary only", []byte{111, 1, 2, 3}, 96, []byte{96, 1, 2, 3}, nil},
+		{"one redundant block", encodeRED([]byte{1, 2, 3}, []byte{4, 5}, 960, 111), 96, encodeRED([]byte{1, 2, 3}, []byte{4, 5}, 960, 96), nil},
+		{"two redundant blocks", []byte{
+			0x80 | 111, 0x1e, 0x00, 0x01,
+			0x80 | 111, 0x0f, 0x00, 0x02,
+			111,
+			6, 4, 5, 1, 2, 3,
+		}, 100, []byte{
+			0x80 | 100, 0x1e, 0x00, 0x01,
+			0x80 | 100, 0x0f, 0x00, 0x02,
+			100,
+			6, 4, 5, 1, 2, 3,
+		}, nil},
+		{"same payload type", []byte{111, 1}, 111, []byte{111, 1}, nil},
+		{"truncated", []byte{0x80 | 111, 0x0f}, 96, nil, errShortRED},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := append([]byte{}, test.payload...)
+			relabelled, err := relabelRED(test.payload, test.payloadType)
+			if err != test.err || !bytes.Equal(relabelled, test.expected) {
+				t.Errorf("relabelRED() = %x, %v, expected %x, %v", relabelled, err, test.expected, test.err)
+			}
+			if !bytes.Equal(test.payload, original) {
+				t.Errorf("relabelRED() modified its input")
+			}
+		})
+	}
+}
+
+func TestREDForwarderPayloadTypes(t *testing.T) {
+	speaker := &types.Client{Nucleus: &types.Nucleus{Config: &types.Config{REDLossThreshold: 5}}}
+	speaker.SetReceiveCodecs(map[string]bool{"audio/opus": true}, false, OPUS_PAYLOAD_TYPE)
+
+	listener := func(payloadType uint8) *types.AudioBundle {
+		track, err := webrtc.NewTrackLocalStaticRTP(webrtc.RTPCodecCapability{MimeType: MIME_TYPE_RED}, "audio", "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &types.Client{}
+		client.SetReceiveCodecs(map[string]bool{"audio/opus": true, "audio/red": true}, true, payloadType)
+		return &types.AudioBundle{Track: track, Listener: client}
+	}
+
+	tests := []struct {
+		name        string
+		inboundRED  bool
+		payload     []byte
+		payloadType uint8
+		expected    []byte
+	}{
+		{"opus to the default mapping", false, []byte{1, 2, 3}, 111, []byte{111, 1, 2, 3}},
+		{"opus to a listener mapping opus to 96", false, []byte{1, 2, 3}, 96, []byte{96, 1, 2, 3}},
+		{"red passed through to the same mapping", true, []byte{111, 1, 2, 3}, 111, []byte{111, 1, 2, 3}},
+		{"red relabelled for a listener mapping opus to 96", true, []byte{111, 1, 2, 3}, 96, []byte{96, 1, 2, 3}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec := "audio/opus"
+			if test.inboundRED {
+				codec = MIME_TYPE_RED
+			}
+			speaker.SetInboundStream(1, codec, 0)
+
+			raw, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 63, SequenceNumber: 1}, Payload: test.payload}).Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			forwarder := newREDForwarder()
+			forwarder.next(speaker, raw)
+
+			sent := &rtp.Packet{}
+			if err := sent.Unmarshal(forwarder.packetFor(speaker, listener(test.payloadType))); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sent.Payload, test.expected) {
+				t.Errorf("sent %x, expected %x", sent.Payload, test.expected)
+			}
+		})
+	}
+}
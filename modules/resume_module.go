@@ -0,0 +1,262 @@
+package modules
+
+import (
+	"crypto/subtle"
+	"log"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+// Messages kept for a client without a socket, the oldest are dropped beyond this.
+var RESUME_BACKLOG = 256
+
+// How long a write to a client's socket can block before the socket is given up on.
+var WRITE_TIMEOUT = 10 * time.Second
+
+// Tell a newly connected client its uuid and the token to resume it with.
+func SendSession(client *types.Client) {
+	client.Resume.Mutex.Lock()
+	message := sessionMessage(client, false)
+	client.Resume.Mutex.Unlock()
+
+	client.WriteChan <- message
+}
+
+// Reattach a socket to the client the token was issued to, connecting from the address.
+// The client is sent a new token followed by everything written to it while it had no
+// socket. Returns nil if no client holds the token.
+func ResumeClient(nucleus *types.Nucleus, token string, socket *types.ThreadSafeWriter, address string) *types.Client {
+	if nucleus.Config.ResumeGrace <= 0 {
+		return nil
+	}
+
+	client := findClientByToken(nucleus, token)
+	if client == nil {
+		return nil
+	}
+
+	client.Resume.Mutex.Lock()
+
+	// The client may have ended or resumed since it was found.
+	if !tokenMatches(client, token) {
+		client.Resume.Mutex.Unlock()
+		return nil
+	}
+
+	// A socket that hasn't noticed it dropped yet is replaced, its reader stops without suspending.
+	previous := client.Socket
+	client.Socket = socket
+	if previous != nil {
+		previous.Conn.Close()
+	}
+
+	client.Resume.Suspended = false
+	if client.Resume.Expiry != nil {
+		client.Resume.Expiry.Stop()
+		client.Resume.Expiry = nil
+	}
+	client.Resume.Token = types.NewToken()
+
+	log.Printf("Client %s resumed with %d backlogged messages\n", client.UUID, len(client.Resume.Backlog))
+
+	// The writer keeps backlogging until the backlog is flushed so nothing newer overtakes it.
+	backlog := append([]*types.WebsocketMessage{sessionMessage(client, true)}, client.Resume.Backlog...)
+	client.Resume.Backlog = nil
+	client.Resume.Flushing = true
+	client.Resume.Mutex.Unlock()
+
+	// Resuming from a new network must not leave the client ended by the next socket
+	// connecting from the old address.
+	nucleus.Mutex.Lock()
+	client.IpAddr = address
+	nucleus.Mutex.Unlock()
+
+	flushBacklog(client, socket, backlog)
+	return client
+}
+
+// Write the backlog to a resumed socket without holding the resume mutex, then pick up
+// whatever the writer backlogged in the meantime until there is nothing left.
+func flushBacklog(client *types.Client, socket *types.ThreadSafeWriter, backlog []*types.WebsocketMessage) {
+	for {
+		for i, message := range backlog {
+			if err := writeSocket(client, socket, message); err != nil {
+				log.Printf("Error flushing backlog to client %s: %s", client.UUID, err)
+				socket.Conn.Close()
+
+				client.Resume.Mutex.Lock()
+				if client.Socket == socket {
+					client.Resume.Backlog = append(backlog[i:], client.Resume.Backlog...)
+					client.Resume.Flushing = false
+				}
+				client.Resume.Mutex.Unlock()
+				return
+			}
+		}
+
+		client.Resume.Mutex.Lock()
+		// Another resume took over the client, it flushes from here.
+		if client.Socket != socket {
+			client.Resume.Mutex.Unlock()
+			return
+		}
+
+		backlog = client.Resume.Backlog
+		client.Resume.Backlog = nil
+		if len(backlog) == 0 {
+			client.Resume.Flushing = false
+			client.Resume.Mutex.Unlock()
+			return
+		}
+		client.Resume.Mutex.Unlock()
+	}
+}
+
+// End the client for good: close its peer connection and remove it from the nucleus.
+func EndClient(client *types.Client) {
+	client.Resume.End.Do(func() {
+		client.Resume.Mutex.Lock()
+		// Without a token the client can't be resumed or suspended again.
+		client.Resume.Token = ""
+		if client.Resume.Expiry != nil {
+			client.Resume.Expiry.Stop()
+			client.Resume.Expiry = nil
+		}
+		client.Resume.Mutex.Unlock()
+
+		if currentPeerConnection(client) != nil {
+			if err := handleDisconnect(client); err != nil {
+				log.Printf("Error disconnecting client %s: %s", client.UUID, err)
+			}
+		}
+
+		shutdownClient(client)
+		close(client.Resume.Ended)
+	})
+}
+
+// Keep a client whose socket dropped for the grace period, its peer connection and
+// registrations carry on. The client is ended if it isn't resumed in time.
+func suspendClient(client *types.Client, socket *types.ThreadSafeWriter) {
+	grace := client.Nucleus.Config.ResumeGrace
+
+	client.Resume.Mutex.Lock()
+	// The socket was replaced by a resume, or the client has already ended.
+	if client.Socket != socket || client.Resume.Token == "" {
+		client.Resume.Mutex.Unlock()
+		return
+	}
+
+	if grace <= 0 {
+		client.Resume.Mutex.Unlock()
+		EndClient(client)
+		return
+	}
+
+	client.Resume.Suspended = true
+	client.Resume.Expiry = time.AfterFunc(grace, func() {
+		expireClient(client)
+	})
+	client.Resume.Mutex.Unlock()
+
+	socket.Conn.Close()
+	log.Printf("Client %s suspended for %s\n", client.UUID, grace)
+}
+
+// End a suspended client that wasn't resumed within the grace period.
+func expireClient(client *types.Client) {
+	client.Resume.Mutex.Lock()
+	if !client.Resume.Suspended {
+		client.Resume.Mutex.Unlock()
+		return
+	}
+	client.Resume.Token = ""
+	client.Resume.Mutex.Unlock()
+
+	log.Printf("Client %s was not resumed in time\n", client.UUID)
+	EndClient(client)
+}
+
+// Write a message to the client's socket, or keep it for when the client resumes if it
+// has none. A socket that can't be written to is closed so that its reader suspends the client.
+func writeMessage(client *types.Client, message *types.WebsocketMessage) {
+	client.Resume.Mutex.Lock()
+	defer client.Resume.Mutex.Unlock()
+
+	if client.Resume.Suspended || client.Resume.Flushing || client.Socket == nil {
+		backlogMessage(client, message)
+		return
+	}
+
+	if err := writeSocket(client, client.Socket, message); err != nil {
+		log.Printf("Write error %+v", err)
+		client.Socket.Conn.Close()
+		backlogMessage(client, message)
+	}
+}
+
+// Only one goroutine writes to a socket at a time: the writer, or the resume flushing its
+// backlog. A message whose payload can't be encoded is logged and dropped, it says
+// nothing about the socket.
+func writeSocket(client *types.Client, socket *types.ThreadSafeWriter, message *types.WebsocketMessage) error {
+	encoded, err := message.Encode(client.Version())
+	if err != nil {
+		log.Printf("Encode error for %s event %+v", message.Event, err)
+		return nil
+	}
+
+	socket.Conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	return socket.Conn.WriteJSON(encoded)
+}
+
+// Requires the resume mutex.
+func backlogMessage(client *types.Client, message *types.WebsocketMessage) {
+	if client.Resume.Token == "" {
+		return
+	}
+
+	client.Resume.Backlog = append(client.Resume.Backlog, message)
+	if overflow := len(client.Resume.Backlog) - RESUME_BACKLOG; overflow > 0 {
+		client.Resume.Backlog = client.Resume.Backlog[overflow:]
+	}
+}
+
+// The websocket client holding the token, nil if there isn't one.
+func findClientByToken(nucleus *types.Nucleus, token string) *types.Client {
+	nucleus.Mutex.RLock()
+	defer nucleus.Mutex.RUnlock()
+
+	for _, client := range nucleus.Clients {
+		if client.HTTPSession != nil || client.Beacon != nil {
+			continue
+		}
+
+		client.Resume.Mutex.Lock()
+		matches := tokenMatches(client, token)
+		client.Resume.Mutex.Unlock()
+
+		if matches {
+			return client
+		}
+	}
+	return nil
+}
+
+// Requires the resume mutex.
+func tokenMatches(client *types.Client, token string) bool {
+	return client.Resume.Token != "" && subtle.ConstantTimeCompare([]byte(client.Resume.Token), []byte(token)) == 1
+}
+
+// Requires the resume mutex.
+func sessionMessage(client *types.Client, resumed bool) *types.WebsocketMessage {
+	return &types.WebsocketMessage{
+		Event: "session",
+		Payload: &types.SessionPayload{
+			UUID:        client.UUID.String(),
+			ResumeToken: client.Resume.Token,
+			ResumeGrace: int(client.Nucleus.Config.ResumeGrace / time.Second),
+			Resumed:     resumed,
+		},
+	}
+}
@@ -0,0 +1,300 @@
+package modules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+func TestTokenMatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		held    string
+		token   string
+		matches bool
+	}{
+		{"same token", "abc123", "abc123", true},
+		{"different token", "abc123", "abc124", false},
+		{"prefix", "abc123", "abc", false},
+		{"longer", "abc123", "abc1234", false},
+		{"empty token", "abc123", "", false},
+		{"ended client", "", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &types.Client{}
+			client.Resume.Token = test.held
+			if matches := tokenMatches(client, test.token); matches != test.matches {
+				t.Errorf("tokenMatches() = %t, expected %t", matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestFindClientByToken(t *testing.T) {
+	client := func(token string) *types.Client {
+		client := &types.Client{UUID: uuid.New()}
+		client.Resume.Token = token
+		return client
+	}
+
+	websocket := client("websocket")
+	ended := client("")
+	session := client("session")
+	session.HTTPSession = &types.HTTPSession{}
+	beacon := client("beacon")
+	beacon.Beacon = &types.Beacon{}
+
+	nucleus := &types.Nucleus{
+		Config:  &types.Config{ResumeGrace: time.Minute},
+		Clients: map[uuid.UUID]*types.Client{},
+	}
+	for _, c := range []*types.Client{websocket, ended, session, beacon} {
+		nucleus.Clients[c.UUID] = c
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		expected *types.Client
+	}{
+		{"websocket client", "websocket", websocket},
+		{"unknown token", "unknown", nil},
+		{"empty token", "", nil},
+		{"HTTP sessions can't be resumed", "session", nil},
+		{"beacons can't be resumed", "beacon", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if found := findClientByToken(nucleus, test.token); found != test.expected {
+				t.Errorf("findClientByToken() = %v, expected %v", found, test.expected)
+			}
+		})
+	}
+
+	nucleus.Config.ResumeGrace = 0
+	if resumed := ResumeClient(nucleus, "websocket", nil, ""); resumed != nil {
+		t.Errorf("ResumeClient() resumed client %s without a grace period", resumed.UUID)
+	}
+}
+
+// A connected websocket pair, the server's end wrapped as the client's socket.
+func socketPair(t *testing.T) (*types.ThreadSafeWriter, *websocket.Conn) {
+	accepted := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		accepted <- conn
+	}))
+	t.Cleanup(server.Close)
+
+	remote, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	return &types.ThreadSafeWriter{Conn: <-accepted}, remote
+}
+
+// The events the remote end reads, in order.
+func readEvents(t *testing.T, remote *websocket.Conn, count int) []string {
+	events := make([]string, 0, count)
+	remote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(events) < count {
+		message := &types.WebsocketMessage{}
+		if err := remote.ReadJSON(message); err != nil {
+			t.Fatalf("read %v, then %s", events, err)
+		}
+		events = append(events, message.Event)
+	}
+	return events
+}
+
+func event(name string) *types.WebsocketMessage {
+	return &types.WebsocketMessage{Event: name, Data: name}
+}
+
+func TestWriteMessageBacklog(t *testing.T) {
+	defer func(backlog int) { RESUME_BACKLOG = backlog }(RESUME_BACKLOG)
+	RESUME_BACKLOG = 3
+
+	socket, _ := socketPair(t)
+
+	tests := []struct {
+		name      string
+		suspended bool
+		flushing  bool
+		socket    *types.ThreadSafeWriter
+		token     string
+		writes    []string
+		backlog   []string
+	}{
+		{"suspended", true, false, socket, "token", []string{"a", "b"}, []string{"a", "b"}},
+		{"flushing a resume", false, true, socket, "token", []string{"a"}, []string{"a"}},
+		{"no socket yet", false, false, nil, "token", []string{"a"}, []string{"a"}},
+		{"oldest dropped beyond the limit", true, false, socket, "token", []string{"a", "b", "c", "d", "e"}, []string{"c", "d", "e"}},
+		{"ended", true, false, socket, "", []string{"a"}, []string{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &types.Client{Socket: test.socket}
+			client.Resume.Token = test.token
+			client.Resume.Suspended = test.suspended
+			client.Resume.Flushing = test.flushing
+
+			for _, name := range test.writes {
+				writeMessage(client, event(name))
+			}
+
+			backlog := make([]string, 0)
+			for _, message := range client.Resume.Backlog {
+				backlog = append(backlog, message.Event)
+			}
+			if strings.Join(backlog, ",") != strings.Join(test.backlog, ",") {
+				t.Errorf("backlog = %v, expected %v", backlog, test.backlog)
+			}
+		})
+	}
+}
+
+func TestFlushBacklog(t *testing.T) {
+	tests := []struct {
+		name    string
+		flushed []string
+		writes  []string
+		closed  bool
+		read    []string
+		backlog []string
+	}{
+		{"in order", []string{"session", "a", "b"}, nil, false, []string{"session", "a", "b"}, nil},
+		{"writes during the flush follow it", []string{"session", "a"}, []string{"b", "c"}, false, []string{"session", "a", "b", "c"}, nil},
+		{"failed write keeps the rest", []string{"session", "a"}, []string{"b"}, true, nil, []string{"session", "a", "b"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			socket, remote := socketPair(t)
+
+			client := &types.Client{Socket: socket}
+			client.Resume.Token = "token"
+			client.Resume.Flushing = true
+
+			// The writer keeps backlogging while the resume flushes.
+			for _, name := range test.writes {
+				writeMessage(client, event(name))
+			}
+			if test.closed {
+				socket.Conn.Close()
+			}
+
+			backlog := make([]*types.WebsocketMessage, 0)
+			for _, name := range test.flushed {
+				backlog = append(backlog, event(name))
+			}
+			flushBacklog(client, socket, backlog)
+
+			if client.Resume.Flushing {
+				t.Errorf("still flushing")
+			}
+			if test.read != nil {
+				if read := readEvents(t, remote, len(test.read)); strings.Join(read, ",") != strings.Join(test.read, ",") {
+					t.Errorf("read %v, expected %v", read, test.read)
+				}
+			}
+
+			left := make([]string, 0)
+			for _, message := range client.Resume.Backlog {
+				left = append(left, message.Event)
+			}
+			if strings.Join(left, ",") != strings.Join(test.backlog, ",") {
+				t.Errorf("backlog = %v, expected %v", left, test.backlog)
+			}
+		})
+	}
+}
+
+func TestResumeClient(t *testing.T) {
+	nucleus := types.CreateNucleus(&types.Config{ResumeGrace: time.Minute})
+
+	previous, _ := socketPair(t)
+	client := types.NewClient(previous, nucleus, "198.51.100.1:1000")
+	nucleus.Clients[client.UUID] = client
+
+	suspendClient(client, previous)
+	if !client.Resume.Suspended || client.Resume.Expiry == nil {
+		t.Fatalf("client wasn't suspended")
+	}
+
+	writeMessage(client, event("a"))
+	writeMessage(client, event("b"))
+
+	token := client.Resume.Token
+	socket, remote := socketPair(t)
+	if resumed := ResumeClient(nucleus, token, socket, "203.0.113.5:2000"); resumed != client {
+		t.Fatalf("ResumeClient() = %v, expected the suspended client", resumed)
+	}
+
+	if read := readEvents(t, remote, 3); strings.Join(read, ",") != "session,a,b" {
+		t.Errorf("read %v, expected the session and then the backlog", read)
+	}
+	if client.Resume.Suspended || client.Resume.Expiry != nil || client.Resume.Flushing || len(client.Resume.Backlog) != 0 {
+		t.Errorf("client left suspended, flushing or with a backlog")
+	}
+	if client.Resume.Token == token {
+		t.Errorf("token wasn't replaced")
+	}
+	if client.IpAddr != "203.0.113.5:2000" {
+		t.Errorf("address = %s, expected the resumed socket's", client.IpAddr)
+	}
+
+	// The old token is spent.
+	if resumed := ResumeClient(nucleus, token, socket, "203.0.113.5:2000"); resumed != nil {
+		t.Errorf("resumed twice with one token")
+	}
+
+	// The dropped socket's reader stops without suspending the resumed client.
+	suspendClient(client, previous)
+	if client.Resume.Suspended {
+		t.Errorf("replaced socket suspended the client")
+	}
+}
+
+func TestExpireClient(t *testing.T) {
+	nucleus := types.CreateNucleus(&types.Config{ResumeGrace: 10 * time.Millisecond})
+	go Enable(nucleus)
+
+	socket, _ := socketPair(t)
+	client := types.NewClient(socket, nucleus, "198.51.100.1:1000")
+	nucleus.Subscribe <- client
+
+	suspendClient(client, socket)
+
+	select {
+	case <-client.Resume.Ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client wasn't ended after its grace period")
+	}
+
+	nucleus.Mutex.RLock()
+	_, found := nucleus.Clients[client.UUID]
+	nucleus.Mutex.RUnlock()
+	if found {
+		t.Errorf("expired client is still in the nucleus")
+	}
+	if findClientByToken(nucleus, client.Resume.Token) != nil || client.Resume.Token != "" {
+		t.Errorf("expired client can still be resumed")
+	}
+}
@@ -0,0 +1,127 @@
+package modules
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/rtcp"
+	"github.com/pion/webrtc/v3"
+)
+
+// Drain RTCP (sender reports, SDES) the client sends about its audio so the receiver's buffers don't fill.
+func readReceiverRTCP(client *types.Client, receiver *webrtc.RTPReceiver) {
+	for {
+		if _, _, err := receiver.ReadRTCP(); err != nil {
+			log.Printf("Client %s stopped reading receiver RTCP: %s\n", client.UUID, err)
+			return
+		}
+	}
+}
+
+// Read the listener's feedback about the track a transceiver slot carries. Receiver reports
+// feed the link stats, NACKs are forwarded to the speaker and congestion feedback feeds the
+// listener's bandwidth estimate. Feedback that arrives while the slot is idle is dropped.
+func readSenderRTCP(listener *types.Client, slot *types.TransceiverSlot) {
+	sender := slot.Transceiver.Sender()
+	if sender == nil {
+		return
+	}
+
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		slot.Mutex.RLock()
+		client, bundle := slot.Speaker, slot.Bundle
+		slot.Mutex.RUnlock()
+
+		if bundle == nil {
+			continue
+		}
+
+		for _, packet := range packets {
+			switch packet := packet.(type) {
+			case *rtcp.ReceiverReport:
+				updateLinkStats(client, bundle, packet)
+			case *rtcp.TransportLayerNack:
+				forwardToSpeaker(client, packet)
+			case *rtcp.TransportLayerCC:
+				handleTransportCC(listener, packet)
+			case *rtcp.ReceiverEstimatedMaximumBitrate:
+				handleREMB(listener, packet)
+			}
+		}
+	}
+}
+
+// Drain the listener's RTCP about a track nothing needs feedback for, so that the sender's
+// interceptors don't back up.
+func drainSenderRTCP(client *types.Client, sender *webrtc.RTPSender) {
+	for {
+		if _, _, err := sender.ReadRTCP(); err != nil {
+			log.Printf("Client %s stopped reading sender RTCP: %s\n", client.UUID, err)
+			return
+		}
+	}
+}
+
+func updateLinkStats(client *types.Client, bundle *types.AudioBundle, report *rtcp.ReceiverReport) {
+	for _, reception := range report.Reports {
+		stats := &types.LinkStats{
+			Speaker:     client.UUID,
+			Listener:    bundle.Listener.UUID,
+			LossPercent: 100 * float64(reception.FractionLost) / 256,
+			TotalLost:   reception.TotalLost,
+			JitterMs:    jitterMs(reception.Jitter, codecClockRate(bundle.Track.Codec().MimeType)),
+			Updated:     time.Now(),
+		}
+
+		bundle.StatsMutex.Lock()
+		bundle.Stats = stats
+		bundle.StatsMutex.Unlock()
+
+		statsMarshaled, err := json.Marshal(stats)
+		if err != nil {
+			log.Printf("Error marshaling link stats: %s", err)
+			continue
+		}
+
+		// Stats are only read while someone is watching, drop them rather than block.
+		select {
+		case client.Nucleus.Stats <- string(statsMarshaled):
+		default:
+		}
+	}
+}
+
+// Send a listener's NACK to the speaker, rewritten to refer to the speaker's own stream.
+func forwardToSpeaker(client *types.Client, nack *rtcp.TransportLayerNack) {
+	client.PCMutex.RLock()
+	defer client.PCMutex.RUnlock()
+
+	ssrc, _ := client.InboundStream()
+	if client.PeerConnection == nil || ssrc == 0 {
+		return
+	}
+
+	forwarded := &rtcp.TransportLayerNack{
+		MediaSSRC: ssrc,
+		Nacks:     make([]rtcp.NackPair, 0, len(nack.Nacks)),
+	}
+
+	// Undo the shift silence suppression applied to the sequence numbers.
+	for _, pair := range nack.Nacks {
+		forwarded.Nacks = append(forwarded.Nacks, rtcp.NackPair{
+			PacketID:    speakerSequenceNumber(client, pair.PacketID),
+			LostPackets: pair.LostPackets,
+		})
+	}
+
+	if err := client.PeerConnection.WriteRTCP([]rtcp.Packet{forwarded}); err != nil {
+		log.Printf("Error forwarding NACK to client %s: %s", client.UUID, err)
+	}
+}
@@ -0,0 +1,70 @@
+package modules
+
+import (
+	"encoding/binary"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/rtp"
+)
+
+const (
+	// The audio level header extension, RFC 6464.
+	AUDIO_LEVEL_URI = "urn:ietf:params:rtp-hdrext:ssrc-audio-level"
+
+	// Opus DTX frames carry no more than the TOC byte and a frame count.
+	opusDTXMaxSize = 2
+)
+
+// Decide whether an inbound packet should be forwarded. Silence (Opus DTX or comfort noise
+// frames, or a low audio level) is dropped apart from a periodic keepalive. Forwarded packets
+// have their sequence numbers shifted so listeners don't mistake dropped silence for loss,
+// and the first packet of each talkspurt is marked. The packet is modified in place.
+func suppressSilence(client *types.Client, packet []byte) bool {
+	config := client.Nucleus.Config
+	if !config.SilenceSuppression || client.Beacon != nil {
+		return true
+	}
+
+	header := &rtp.Header{}
+	if err := header.Unmarshal(packet); err != nil {
+		return true
+	}
+
+	silent := len(packet)-header.PayloadOffset <= opusDTXMaxSize
+	if extID := client.AudioLevelExtension(); !silent && extID != 0 {
+		if level := header.GetExtension(extID); len(level) > 0 {
+			silent = int(level[0]&0x7f) >= config.SilenceLevel
+		}
+	}
+
+	state := &client.Silence
+	state.Mutex.Lock()
+	defer state.Mutex.Unlock()
+
+	now := time.Now()
+	if silent && now.Sub(state.LastForwarded) < config.SilenceKeepalive {
+		state.Silent = true
+		state.SeqOffset++
+		return false
+	}
+
+	// Mark the start of a talkspurt.
+	if state.Silent && !silent {
+		packet[1] |= 0x80
+	}
+	state.Silent = silent
+	state.LastForwarded = now
+
+	binary.BigEndian.PutUint16(packet[2:4], header.SequenceNumber-state.SeqOffset)
+	return true
+}
+
+// Map a sequence number a listener saw back to the one the speaker sent. Only exact for
+// packets forwarded since the speaker's last dropped silence.
+func speakerSequenceNumber(client *types.Client, forwarded uint16) uint16 {
+	client.Silence.Mutex.Lock()
+	defer client.Silence.Mutex.Unlock()
+
+	return forwarded + client.Silence.SeqOffset
+}
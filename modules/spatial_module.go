@@ -0,0 +1,76 @@
+package modules
+
+import (
+	"log"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+var (
+	// How often each listener is sent the geometry of the peers it can hear.
+	SPATIAL_UPDATE_INTERVAL = time.Second
+)
+
+// Periodically send the client the distance and relative bearing of every peer it can hear
+// so that it can pan and attenuate each incoming stream.
+func spatialUpdates(client *types.Client) {
+	ticker := time.NewTicker(SPATIAL_UPDATE_INTERVAL)
+	defer func() {
+		ticker.Stop()
+		log.Printf("Client %s stopped spatial updates\n", client.UUID)
+	}()
+
+	for {
+		select {
+		case <-client.StopSpatial:
+			return
+		case <-ticker.C:
+			spatial := peerGeometry(client)
+			if len(spatial) == 0 {
+				break
+			}
+
+			client.WriteChan <- &types.WebsocketMessage{
+				Event:   "peer_spatial",
+				Payload: spatial,
+			}
+		}
+	}
+}
+
+// Compile the geometry of every peer that has registered the client as a listener.
+func peerGeometry(client *types.Client) []*types.SpatialData {
+	spatial := make([]*types.SpatialData, 0)
+
+	if client.CurrentLocation == nil {
+		return spatial
+	}
+
+	client.Nucleus.Mutex.RLock()
+	defer client.Nucleus.Mutex.RUnlock()
+
+	for peer_uuid, peer := range client.Nucleus.Clients {
+		if peer_uuid == client.UUID || peer.CurrentLocation == nil {
+			continue
+		}
+
+		peer.RCMutex.RLock()
+		bundle := peer.RegisteredClients[client.UUID]
+		peer.RCMutex.RUnlock()
+
+		if bundle == nil {
+			continue
+		}
+
+		spatial = append(spatial, &types.SpatialData{
+			UUID:     peer_uuid,
+			StreamID: bundle.Track.StreamID(),
+			Mid:      bundle.Transceiver.Mid(),
+			Distance: types.Distance(client.CurrentLocation, peer.CurrentLocation),
+			Bearing:  types.RelativeBearing(client.CurrentLocation, peer.CurrentLocation),
+		})
+	}
+
+	return spatial
+}
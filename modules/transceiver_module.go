@@ -0,0 +1,126 @@
+package modules
+
+import (
+	"errors"
+	"log"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+var errNoFreeSlot = errors.New("no free transceiver on a listener that can't be renegotiated")
+
+// Send the track to the listener on an idle transceiver from its pool, or on a new one when
+// the pool is empty. Reused transceivers are already negotiated, so only new ones cause a
+// renegotiation.
+func acquireSlot(listener *types.Client, track *webrtc.TrackLocalStaticRTP) (*types.TransceiverSlot, bool, error) {
+	for slot := popIdleSlot(listener); slot != nil; slot = popIdleSlot(listener) {
+		if err := slot.Transceiver.Sender().ReplaceTrack(track); err != nil {
+			log.Printf("Error reusing transceiver %s on client %s: %s\n", slot.Transceiver.Mid(), listener.UUID, err)
+			stopSlot(listener, slot)
+			continue
+		}
+		return slot, true, nil
+	}
+
+	// HTTP sessions only have the transceivers their offer asked for.
+	if listener.HTTPSession != nil {
+		return nil, false, errNoFreeSlot
+	}
+
+	transceiver, err := listener.PeerConnection.AddTransceiverFromTrack(track, webrtc.RTPTransceiverInit{
+		Direction: webrtc.RTPTransceiverDirectionSendonly})
+	if err != nil {
+		return nil, false, err
+	}
+
+	slot := &types.TransceiverSlot{Transceiver: transceiver}
+
+	// Read the listener's receiver reports and NACKs for as long as the transceiver lives.
+	go readSenderRTCP(listener, slot)
+
+	return slot, false, nil
+}
+
+// Return the slot to the listener's pool with a silent track bound in place of the
+// speaker's. Slots beyond the pool size are stopped.
+func releaseSlot(listener *types.Client, slot *types.TransceiverSlot, codec webrtc.RTPCodecCapability) {
+	slot.Mutex.Lock()
+	slot.Speaker = nil
+	slot.Bundle = nil
+	slot.Mutex.Unlock()
+
+	idle, err := webrtc.NewTrackLocalStaticRTP(codec, "sfu_idle", "sfu_idle")
+	if err == nil {
+		err = slot.Transceiver.Sender().ReplaceTrack(idle)
+	}
+	if err != nil {
+		log.Printf("Error idling transceiver %s on client %s: %s\n", slot.Transceiver.Mid(), listener.UUID, err)
+		stopSlot(listener, slot)
+		return
+	}
+
+	// Stopping an HTTP session's transceiver would lose it for good.
+	listener.SlotsMutex.Lock()
+	pooled := len(listener.IdleSlots) < listener.Nucleus.Config.TransceiverPoolSize || listener.HTTPSession != nil
+	if pooled {
+		listener.IdleSlots = append(listener.IdleSlots, slot)
+
+		// Speakers waiting for a slot can try again.
+		listener.AwaitingSlot = make(map[uuid.UUID]bool)
+	}
+	listener.SlotsMutex.Unlock()
+
+	if !pooled {
+		stopSlot(listener, slot)
+	}
+}
+
+// Remember that the speaker found no free slot on the listener.
+func awaitSlot(listener *types.Client, speaker *types.Client) {
+	listener.SlotsMutex.Lock()
+	listener.AwaitingSlot[speaker.UUID] = true
+	listener.SlotsMutex.Unlock()
+}
+
+// Whether the speaker is waiting for a slot on the listener to be released, registering
+// it before then would only fail again.
+func awaitingSlot(listener *types.Client, speaker *types.Client) bool {
+	listener.SlotsMutex.Lock()
+	defer listener.SlotsMutex.Unlock()
+	return listener.AwaitingSlot[speaker.UUID]
+}
+
+func popIdleSlot(listener *types.Client) *types.TransceiverSlot {
+	listener.SlotsMutex.Lock()
+	defer listener.SlotsMutex.Unlock()
+
+	if len(listener.IdleSlots) == 0 {
+		return nil
+	}
+
+	slot := listener.IdleSlots[len(listener.IdleSlots)-1]
+	listener.IdleSlots = listener.IdleSlots[:len(listener.IdleSlots)-1]
+	return slot
+}
+
+// Remove the slot's m-line from the listener's peer connection for good.
+func stopSlot(listener *types.Client, slot *types.TransceiverSlot) {
+	if err := listener.PeerConnection.RemoveTrack(slot.Transceiver.Sender()); err != nil {
+		log.Printf("Error removing track on unregistree peer connection %s\n", err)
+	}
+
+	slot.Transceiver.Stop()
+}
+
+// Tell the listener which peer a reused transceiver now carries.
+func sendTrackMapping(listener *types.Client, slot *types.TransceiverSlot, speaker *types.Client) {
+	listener.WriteChan <- &types.WebsocketMessage{
+		Event: "track_mapping",
+		Payload: &types.TrackMapping{
+			Mid:  slot.Transceiver.Mid(),
+			UUID: speaker.UUID,
+		},
+	}
+}
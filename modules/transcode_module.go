@@ -0,0 +1,247 @@
+package modules
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+// Converts one RTP payload from one codec to another. Both codecs share a clock rate and
+// frame size so RTP timestamps carry over unchanged.
+type transcoder func(payload []byte) []byte
+
+var (
+	// Codecs listeners are sent in order of preference.
+	CODEC_PREFERENCE = []string{webrtc.MimeTypeOpus, webrtc.MimeTypeG722, webrtc.MimeTypePCMA, webrtc.MimeTypePCMU}
+
+	// Available transcoders keyed by source and then destination mime type (lower case).
+	// Only G.711 is converted in process, there is no Opus encoder. Speakers and listeners
+	// that share no codec and have no transcoder between them aren't registered to each
+	// other, the listener is sent an unsupported_codec error instead.
+	TRANSCODERS = map[string]map[string]transcoder{
+		strings.ToLower(webrtc.MimeTypePCMU): {strings.ToLower(webrtc.MimeTypePCMA): ulawToAlaw},
+		strings.ToLower(webrtc.MimeTypePCMA): {strings.ToLower(webrtc.MimeTypePCMU): alawToUlaw},
+	}
+)
+
+// Pick the codec to send a speaker's audio to a listener in. A shared codec is always
+// preferred, transcoding is only used when there isn't one. The transcoder is nil when
+// no conversion is needed. The codec is always one the listener negotiated, when the
+// listener can't be served (or hasn't negotiated yet) it is empty and ok is false.
+func selectCodec(speakerCodec string, listenerCodecs map[string]bool) (codec string, convert transcoder, ok bool) {
+	speakerCodec = sentCodec(speakerCodec)
+	if listenerCodecs[speakerCodec] {
+		return speakerCodec, nil, true
+	}
+
+	for _, codec := range CODEC_PREFERENCE {
+		codec = strings.ToLower(codec)
+		if !listenerCodecs[codec] {
+			continue
+		}
+		if convert := TRANSCODERS[speakerCodec][codec]; convert != nil {
+			return codec, convert, true
+		}
+	}
+
+	return "", nil, false
+}
+
+// The lower case codec a speaker's audio is forwarded as. Audio of an unknown codec is
+// assumed to be Opus and RED is forwarded as its primary Opus encoding.
+func sentCodec(speakerCodec string) string {
+	speakerCodec = strings.ToLower(speakerCodec)
+	if speakerCodec == "" || speakerCodec == strings.ToLower(MIME_TYPE_RED) {
+		return strings.ToLower(webrtc.MimeTypeOpus)
+	}
+	return speakerCodec
+}
+
+// The codec and transcoder a speaker's audio is sent to a listener with. Listeners that
+// negotiated RED are sent it so that redundancy can be added for them. ok is false when the
+// listener can't be sent the speaker's audio.
+func listenerCodec(speaker *types.Client, listener *types.Client) (codec string, convert transcoder, ok bool) {
+	_, speakerCodec := speaker.InboundStream()
+	listenerCodecs, red := listener.ReceiveCodecs()
+
+	codec, convert, ok = selectCodec(speakerCodec, listenerCodecs)
+	if codec == strings.ToLower(webrtc.MimeTypeOpus) && red {
+		codec = MIME_TYPE_RED
+	}
+	return codec, convert, ok
+}
+
+// Whether the listener can be sent the speaker's audio in a codec it negotiated. A listener
+// that can't is told so rather than the speaker being left out silently.
+func canHear(speaker *types.Client, listener *types.Client) bool {
+	if _, _, ok := listenerCodec(speaker, listener); ok {
+		return true
+	}
+	reportUnsupportedCodec(speaker, listener)
+	return false
+}
+
+// Send the listener an unsupported_codec error for the speaker, once per speaker and codec
+// until the listener renegotiates. Listeners that haven't negotiated yet aren't told.
+func reportUnsupportedCodec(speaker *types.Client, listener *types.Client) {
+	listenerCodecs, _ := listener.ReceiveCodecs()
+	if len(listenerCodecs) == 0 {
+		return
+	}
+
+	_, speakerCodec := speaker.InboundStream()
+	speakerCodec = sentCodec(speakerCodec)
+	if !listener.ReportUnsupportedCodec(speaker.UUID, speakerCodec) {
+		return
+	}
+
+	negotiated := make([]string, 0, len(listenerCodecs))
+	for codec := range listenerCodecs {
+		negotiated = append(negotiated, codec)
+	}
+	sort.Strings(negotiated)
+
+	log.Printf("Client %s has no codec for client %s's %s\n", listener.UUID, speaker.UUID, speakerCodec)
+	listener.WriteChan <- &types.WebsocketMessage{
+		Event: "error",
+		Payload: &types.ErrorPayload{
+			Code:    types.ERROR_UNSUPPORTED_CODEC,
+			Message: fmt.Sprintf("%s can't be sent as any of %s", speakerCodec, strings.Join(negotiated, ", ")),
+			Peer:    &speaker.UUID,
+		},
+	}
+}
+
+// Once a speaker's audio arrives its codec is known. Listeners registered while it was
+// assumed to be Opus are unregistered if they should be sent something else, they are
+// registered again with the right codec if they can be served at all.
+func reselectCodecs(speaker *types.Client) {
+	speaker.RCMutex.RLock()
+	stale := make([]*types.Client, 0)
+	for _, bundle := range speaker.RegisteredClients {
+		codec, _, ok := listenerCodec(speaker, bundle.Listener)
+		if !ok || !strings.EqualFold(codec, bundle.Track.Codec().MimeType) {
+			stale = append(stale, bundle.Listener)
+		}
+	}
+	speaker.RCMutex.RUnlock()
+
+	_, codec := speaker.InboundStream()
+	for _, listener := range stale {
+		log.Printf("Client %s sends %s, re-registering client %s\n", speaker.UUID, codec, listener.UUID)
+		speaker.WriteChan <- &types.WebsocketMessage{
+			Event:   "peer",
+			Data:    "disconnected peer" + listener.UUID.String(),
+			Payload: &types.PeerStatePayload{UUID: listener.UUID, State: types.PEER_DISCONNECTED},
+		}
+		unregister(speaker, listener)
+	}
+}
+
+func ulawToAlaw(payload []byte) []byte {
+	converted := make([]byte, len(payload))
+	for i, sample := range payload {
+		converted[i] = linearToAlaw(ulawToLinear(sample))
+	}
+	return converted
+}
+
+func alawToUlaw(payload []byte) []byte {
+	converted := make([]byte, len(payload))
+	for i, sample := range payload {
+		converted[i] = linearToUlaw(alawToLinear(sample))
+	}
+	return converted
+}
+
+// G.711 companding, ITU-T G.711 / Sun g711.c.
+
+func ulawToLinear(u byte) int16 {
+	u = ^u
+	t := (int16(u&0x0f) << 3) + 0x84
+	t <<= (u & 0x70) >> 4
+	if u&0x80 != 0 {
+		return 0x84 - t
+	}
+	return t - 0x84
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int16(a&0x0f) << 4
+	segment := (a & 0x70) >> 4
+	switch segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= segment - 1
+	}
+	if a&0x80 != 0 {
+		return t
+	}
+	return -t
+}
+
+var (
+	g711SegmentEnds = [8]int32{0xff, 0x1ff, 0x3ff, 0x7ff, 0xfff, 0x1fff, 0x3fff, 0x7fff}
+)
+
+func g711Segment(value int32) int {
+	for segment, end := range g711SegmentEnds {
+		if value <= end {
+			return segment
+		}
+	}
+	return 8
+}
+
+func linearToUlaw(sample int16) byte {
+	const bias = 0x84
+
+	value := int32(sample)
+	mask := byte(0xff)
+	if value < 0 {
+		value = -value
+		mask = 0x7f
+	}
+	value += bias
+	if value > 0x7fff {
+		value = 0x7fff
+	}
+
+	segment := g711Segment(value)
+	if segment >= 8 {
+		return 0x7f ^ mask
+	}
+	return (byte(segment<<4) | byte((value>>(uint(segment)+3))&0x0f)) ^ mask
+}
+
+func linearToAlaw(sample int16) byte {
+	// A-law works on 13 bit samples.
+	value := int32(sample) >> 3
+	mask := byte(0xd5)
+	if value < 0 {
+		value = -value - 1
+		mask = 0x55
+	}
+
+	segment := g711Segment(value << 3)
+	if segment >= 8 {
+		return 0x7f ^ mask
+	}
+
+	encoded := byte(segment << 4)
+	if segment < 2 {
+		encoded |= byte(value>>1) & 0x0f
+	} else {
+		encoded |= byte(value>>uint(segment)) & 0x0f
+	}
+	return encoded ^ mask
+}
@@ -0,0 +1,202 @@
+package modules
+
+import (
+	"testing"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+func TestSelectCodec(t *testing.T) {
+	tests := []struct {
+		name      string
+		speaker   string
+		listener  map[string]bool
+		codec     string
+		transcode bool
+		ok        bool
+	}{
+		{"shared opus", "audio/opus", map[string]bool{"audio/opus": true}, "audio/opus", false, true},
+		{"unknown speaker codec is assumed opus", "", map[string]bool{"audio/opus": true}, "audio/opus", false, true},
+		{"red is sent as opus", "audio/red", map[string]bool{"audio/opus": true}, "audio/opus", false, true},
+		{"mime type case is ignored", "audio/PCMU", map[string]bool{"audio/pcmu": true}, "audio/pcmu", false, true},
+		{"shared codec over transcoding", "audio/pcmu", map[string]bool{"audio/pcma": true, "audio/pcmu": true}, "audio/pcmu", false, true},
+		{"ulaw to alaw", "audio/pcmu", map[string]bool{"audio/pcma": true}, "audio/pcma", true, true},
+		{"alaw to ulaw", "audio/pcma", map[string]bool{"audio/opus": true, "audio/pcmu": true}, "audio/pcmu", true, true},
+		{"no opus encoder", "audio/pcmu", map[string]bool{"audio/opus": true}, "", false, false},
+		{"no opus decoder", "audio/opus", map[string]bool{"audio/pcmu": true}, "", false, false},
+		{"no g722 transcoder", "audio/g722", map[string]bool{"audio/pcma": true}, "", false, false},
+		{"listener hasn't negotiated", "audio/opus", nil, "", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			codec, convert, ok := selectCodec(test.speaker, test.listener)
+			if codec != test.codec || (convert != nil) != test.transcode || ok != test.ok {
+				t.Errorf("selectCodec() = %s, transcoder %t, ok %t, expected %s, transcoder %t, ok %t",
+					codec, convert != nil, ok, test.codec, test.transcode, test.ok)
+			}
+		})
+	}
+}
+
+func TestCanHear(t *testing.T) {
+	speaker := &types.Client{}
+	speaker.UUID[0] = 1
+	listener := &types.Client{WriteChan: make(chan *types.WebsocketMessage, 8)}
+
+	sentErrors := func() []*types.ErrorPayload {
+		payloads := make([]*types.ErrorPayload, 0)
+		for len(listener.WriteChan) > 0 {
+			payloads = append(payloads, (<-listener.WriteChan).Payload.(*types.ErrorPayload))
+		}
+		return payloads
+	}
+
+	// Nothing is reported before the listener negotiates.
+	speaker.SetInboundStream(1, "audio/pcmu", 0)
+	if canHear(speaker, listener) || len(sentErrors()) != 0 {
+		t.Errorf("listener without codecs was told about them")
+	}
+
+	listener.SetReceiveCodecs(map[string]bool{"audio/opus": true}, false, OPUS_PAYLOAD_TYPE)
+	if canHear(speaker, listener) {
+		t.Fatalf("opus listener can hear a pcmu speaker")
+	}
+	reported := sentErrors()
+	if len(reported) != 1 || reported[0].Code != types.ERROR_UNSUPPORTED_CODEC || reported[0].Peer == nil || *reported[0].Peer != speaker.UUID {
+		t.Fatalf("reported %+v, expected one unsupported_codec error for the speaker", reported)
+	}
+
+	// Once per speaker and codec.
+	canHear(speaker, listener)
+	if len(sentErrors()) != 0 {
+		t.Errorf("unsupported codec reported twice")
+	}
+	speaker.SetInboundStream(1, "audio/g722", 0)
+	canHear(speaker, listener)
+	if len(sentErrors()) != 1 {
+		t.Errorf("new speaker codec wasn't reported")
+	}
+
+	// Renegotiating with a codec the audio can be transcoded to.
+	speaker.SetInboundStream(1, "audio/pcmu", 0)
+	listener.SetReceiveCodecs(map[string]bool{"audio/opus": true, "audio/pcma": true}, false, OPUS_PAYLOAD_TYPE)
+	if !canHear(speaker, listener) || len(sentErrors()) != 0 {
+		t.Errorf("listener that negotiated pcma can't hear a pcmu speaker")
+	}
+
+	// Renegotiating forgets what was reported.
+	listener.SetReceiveCodecs(map[string]bool{"audio/opus": true}, false, OPUS_PAYLOAD_TYPE)
+	canHear(speaker, listener)
+	if len(sentErrors()) != 1 {
+		t.Errorf("unsupported codec wasn't reported again after renegotiating")
+	}
+}
+
+func TestG711Decode(t *testing.T) {
+	ulaw := []struct {
+		encoded byte
+		linear  int16
+	}{
+		{0xff, 0},
+		{0x7f, 0},
+		{0xfe, 8},
+		{0x7e, -8},
+		{0xf0, 120},
+		{0x80, 32124},
+		{0x00, -32124},
+	}
+
+	for _, test := range ulaw {
+		if linear := ulawToLinear(test.encoded); linear != test.linear {
+			t.Errorf("ulawToLinear(%#x) = %d, expected %d", test.encoded, linear, test.linear)
+		}
+	}
+
+	alaw := []struct {
+		encoded byte
+		linear  int16
+	}{
+		{0xd5, 8},
+		{0x55, -8},
+		{0xd4, 24},
+		{0xc5, 264},
+		{0xaa, 32256},
+		{0x2a, -32256},
+	}
+
+	for _, test := range alaw {
+		if linear := alawToLinear(test.encoded); linear != test.linear {
+			t.Errorf("alawToLinear(%#x) = %d, expected %d", test.encoded, linear, test.linear)
+		}
+	}
+}
+
+func TestG711Encode(t *testing.T) {
+	tests := []struct {
+		linear int16
+		ulaw   byte
+		alaw   byte
+	}{
+		{0, 0xff, 0xd5},
+		{-1, 0x7f, 0x55},
+		{8, 0xfe, 0xd5},
+		{-8, 0x7e, 0x55},
+		{1000, 0xce, 0xfa},
+		{-1000, 0x4e, 0x7a},
+		{32767, 0x80, 0xaa},
+		{-32768, 0x00, 0x2a},
+	}
+
+	for _, test := range tests {
+		if ulaw := linearToUlaw(test.linear); ulaw != test.ulaw {
+			t.Errorf("linearToUlaw(%d) = %#x, expected %#x", test.linear, ulaw, test.ulaw)
+		}
+		if alaw := linearToAlaw(test.linear); alaw != test.alaw {
+			t.Errorf("linearToAlaw(%d) = %#x, expected %#x", test.linear, alaw, test.alaw)
+		}
+	}
+}
+
+func TestG711RoundTrip(t *testing.T) {
+	for i := 0; i < 256; i++ {
+		encoded := byte(i)
+
+		// Negative zero has no encoding of its own, it comes back as positive zero.
+		expected := encoded
+		if encoded == 0x7f {
+			expected = 0xff
+		}
+		if ulaw := linearToUlaw(ulawToLinear(encoded)); ulaw != expected {
+			t.Errorf("ulaw %#x round tripped to %#x", encoded, ulaw)
+		}
+
+		if alaw := linearToAlaw(alawToLinear(encoded)); alaw != encoded {
+			t.Errorf("alaw %#x round tripped to %#x", encoded, alaw)
+		}
+	}
+}
+
+func TestG711Transcoders(t *testing.T) {
+	tests := []struct {
+		name     string
+		convert  transcoder
+		payload  []byte
+		expected []byte
+	}{
+		{"ulaw silence to alaw", ulawToAlaw, []byte{0xff, 0x7f}, []byte{0xd5, 0xd5}},
+		{"ulaw peaks to alaw", ulawToAlaw, []byte{0x80, 0x00}, []byte{0xaa, 0x2a}},
+		{"alaw silence to ulaw", alawToUlaw, []byte{0xd5, 0x55}, []byte{0xfe, 0x7e}},
+		{"alaw peaks to ulaw", alawToUlaw, []byte{0xaa, 0x2a}, []byte{0x80, 0x00}},
+		{"empty payload", ulawToAlaw, []byte{}, []byte{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			converted := test.convert(test.payload)
+			if string(converted) != string(test.expected) {
+				t.Errorf("converted %x to %x, expected %x", test.payload, converted, test.expected)
+			}
+		})
+	}
+}
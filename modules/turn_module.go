@@ -0,0 +1,83 @@
+package modules
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/pion/webrtc/v3"
+)
+
+var ErrUnknownSession = errors.New("unknown session token")
+
+// Issue TURN credentials to the websocket client bearing the session token it was sent,
+// the credentials are for the client's uuid.
+func ClientTURNCredentials(nucleus *types.Nucleus, token string) (*types.TURNCredentials, error) {
+	client := findClientByToken(nucleus, token)
+	if client == nil {
+		return nil, ErrUnknownSession
+	}
+
+	return turnCredentials(nucleus, client.UUID.String())
+}
+
+// Issue TURN credentials using the shared secret scheme: the username is the expiry time
+// and the user, the password the base64 HMAC-SHA1 of the username keyed with the secret.
+func turnCredentials(nucleus *types.Nucleus, user string) (*types.TURNCredentials, error) {
+	config := nucleus.Config
+	if config.TURNSecret == "" || len(config.TURNServers) == 0 {
+		return nil, errors.New("no TURN servers are configured")
+	}
+
+	username := fmt.Sprintf("%d", time.Now().Add(config.TURNCredentialTTL).Unix())
+	if user != "" {
+		username += ":" + user
+	}
+
+	return &types.TURNCredentials{
+		Username: username,
+		Password: turnPassword(config.TURNSecret, username),
+		TTL:      int(config.TURNCredentialTTL.Seconds()),
+		URIs:     config.TURNServers,
+	}, nil
+}
+
+// The base64 HMAC-SHA1 of the username keyed with the shared secret.
+func turnPassword(secret string, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// The ICE servers for a user, the configured STUN servers plus the TURN servers with fresh credentials.
+func iceServers(nucleus *types.Nucleus, user string) []webrtc.ICEServer {
+	servers := make([]webrtc.ICEServer, 0)
+
+	if len(nucleus.Config.ICEServers) > 0 {
+		servers = append(servers, webrtc.ICEServer{URLs: nucleus.Config.ICEServers})
+	}
+
+	if credentials, err := turnCredentials(nucleus, user); err == nil {
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           credentials.URIs,
+			Username:       credentials.Username,
+			Credential:     credentials.Password,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+
+	return servers
+}
+
+// Send the client the ICE servers to configure its peer connection with.
+func sendICEServers(client *types.Client) error {
+	client.WriteChan <- &types.WebsocketMessage{
+		Event:   "ice_servers",
+		Payload: &types.ICEServersPayload{ICEServers: iceServers(client.Nucleus, client.UUID.String())},
+	}
+	return nil
+}
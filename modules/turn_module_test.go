@@ -0,0 +1,81 @@
+package modules
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+)
+
+func TestTURNPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		username string
+		expected string
+	}{
+		{"known vector", "key", "The quick brown fox jumps over the lazy dog", "3nybhbi3iqa8ino29wqQcBydtNk="},
+		{"empty username", "key", "", "9Cuw7rAY671Fl65yE3EexgdghD8="},
+		{"expiry and user", "secret", "1700000000:user", "I/MZeIG6MwzOV8Uubr9liB2556o="},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if password := turnPassword(test.secret, test.username); password != test.expected {
+				t.Errorf("turnPassword() = %s, expected %s", password, test.expected)
+			}
+		})
+	}
+
+	if turnPassword("secret", "1700000000:user") == turnPassword("other", "1700000000:user") {
+		t.Errorf("turnPassword() doesn't depend on the secret")
+	}
+}
+
+func TestTURNCredentials(t *testing.T) {
+	servers := []string{"turn:turn.example.com:3478"}
+
+	tests := []struct {
+		name   string
+		config *types.Config
+		user   string
+		err    bool
+	}{
+		{"with user", &types.Config{TURNSecret: "secret", TURNServers: servers, TURNCredentialTTL: time.Hour}, "user", false},
+		{"without user", &types.Config{TURNSecret: "secret", TURNServers: servers, TURNCredentialTTL: time.Hour}, "", false},
+		{"no secret", &types.Config{TURNServers: servers, TURNCredentialTTL: time.Hour}, "user", true},
+		{"no servers", &types.Config{TURNSecret: "secret", TURNCredentialTTL: time.Hour}, "user", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := time.Now()
+			credentials, err := turnCredentials(&types.Nucleus{Config: test.config}, test.user)
+			if (err != nil) != test.err {
+				t.Fatalf("turnCredentials() error = %v, expected error %t", err, test.err)
+			}
+			if test.err {
+				return
+			}
+
+			fields := strings.SplitN(credentials.Username, ":", 2)
+			if test.user == "" && len(fields) != 1 || test.user != "" && (len(fields) != 2 || fields[1] != test.user) {
+				t.Errorf("username %s isn't for user %q", credentials.Username, test.user)
+			}
+
+			expiry, err := strconv.ParseInt(fields[0], 10, 64)
+			if err != nil || expiry < before.Add(time.Hour).Unix() || expiry > time.Now().Add(time.Hour).Unix() {
+				t.Errorf("username %s doesn't expire in an hour", credentials.Username)
+			}
+
+			if credentials.Password != turnPassword("secret", credentials.Username) {
+				t.Errorf("password %s isn't the username's HMAC", credentials.Password)
+			}
+			if credentials.TTL != 3600 || len(credentials.URIs) != 1 || credentials.URIs[0] != servers[0] {
+				t.Errorf("credentials have TTL %d and URIs %v", credentials.TTL, credentials.URIs)
+			}
+		})
+	}
+}
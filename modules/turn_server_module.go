@@ -0,0 +1,234 @@
+package modules
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+	"github.com/pion/turn/v2"
+)
+
+var (
+	// Networks a relay may not reach, besides loopback, link local and multicast addresses.
+	PRIVATE_NETWORKS = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7")
+)
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Run a TURN server inside the process for clients that can't reach the SFU directly. It
+// accepts the credentials handed out over signaling and /turn-credentials, and is added to
+// the TURN servers those credentials are for.
+func StartTURNServer(nucleus *types.Nucleus) error {
+	config := nucleus.Config
+
+	publicIP := net.ParseIP(config.TURNPublicIP)
+	if publicIP == nil {
+		return errors.New("the embedded TURN server needs a public IP")
+	}
+
+	// Without a configured secret only this process can issue credentials.
+	if config.TURNSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return err
+		}
+		config.TURNSecret = hex.EncodeToString(secret)
+	}
+
+	udpListener, err := net.ListenPacket("udp4", config.TURNListen)
+	if err != nil {
+		return err
+	}
+
+	nucleus.TURN = &types.TURNStats{}
+
+	// The server runs for the life of the process.
+	_, err = turn.NewServer(turn.ServerConfig{
+		Realm:       config.TURNRealm,
+		AuthHandler: turnAuthHandler(nucleus),
+		PacketConnConfigs: []turn.PacketConnConfig{
+			{
+				PacketConn: udpListener,
+				RelayAddressGenerator: &countingRelayGenerator{
+					RelayAddressGenerator: &turn.RelayAddressGeneratorPortRange{
+						RelayAddress: publicIP,
+						Address:      "0.0.0.0",
+						MinPort:      uint16(config.TURNRelayMinPort),
+						MaxPort:      uint16(config.TURNRelayMaxPort),
+					},
+					nucleus: nucleus,
+				},
+			},
+		},
+	})
+	if err != nil {
+		udpListener.Close()
+		nucleus.TURN = nil
+		return err
+	}
+
+	port := udpListener.LocalAddr().(*net.UDPAddr).Port
+	url := fmt.Sprintf("turn:%s:%d?transport=udp", publicIP, port)
+	config.TURNServers = append(config.TURNServers, url)
+
+	log.Printf("TURN server listening on %s, relaying on ports %d-%d\n", url, config.TURNRelayMinPort, config.TURNRelayMaxPort)
+	return nil
+}
+
+// Authenticate the shared secret credentials issued by turnCredentials, whose usernames
+// are the expiry time followed by a colon and the uuid of the client they were issued to.
+// Only clients that are still connected may allocate relays.
+func turnAuthHandler(nucleus *types.Nucleus) turn.AuthHandler {
+	return func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
+		fields := strings.SplitN(username, ":", 2)
+		if len(fields) != 2 {
+			return nil, false
+		}
+
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil || expiry < time.Now().Unix() {
+			return nil, false
+		}
+
+		clientUUID, err := uuid.Parse(fields[1])
+		if err != nil {
+			return nil, false
+		}
+
+		nucleus.Mutex.RLock()
+		client := nucleus.Clients[clientUUID]
+		nucleus.Mutex.RUnlock()
+		if client == nil {
+			return nil, false
+		}
+
+		return turn.GenerateAuthKey(username, realm, turnPassword(nucleus.Config.TURNSecret, username)), true
+	}
+}
+
+// Whether a relay may exchange packets with the peer. Loopback, private and link local
+// addresses are refused so the relay can't reach into the server's own network, except
+// for the addresses the server itself is reached on.
+func relayPeerAllowed(nucleus *types.Nucleus, addr net.Addr) bool {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	ip := udpAddr.IP
+	if ip.Equal(net.ParseIP(nucleus.Config.TURNPublicIP)) {
+		return true
+	}
+	// NAT 1:1 mappings may be given as public/private pairs.
+	for _, mapping := range nucleus.Config.NAT1To1IPs {
+		for _, mapped := range strings.Split(mapping, "/") {
+			if ip.Equal(net.ParseIP(mapped)) {
+				return true
+			}
+		}
+	}
+
+	return !(ip.IsLoopback() || isPrivateIP(ip) || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast())
+}
+
+// Whether the address is in an RFC 1918 range or is an IPv6 unique local address (RFC 4193).
+func isPrivateIP(ip net.IP) bool {
+	for _, network := range PRIVATE_NETWORKS {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts the relays allocated by the generator it wraps.
+type countingRelayGenerator struct {
+	turn.RelayAddressGenerator
+	nucleus *types.Nucleus
+}
+
+func (g *countingRelayGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		updateTURNStats(g.nucleus, func(stats *types.TURNStats) { stats.FailedAllocations++ })
+		return nil, nil, err
+	}
+
+	updateTURNStats(g.nucleus, func(stats *types.TURNStats) {
+		stats.Allocations++
+		stats.TotalAllocations++
+	})
+	return &countedPacketConn{PacketConn: conn, nucleus: g.nucleus}, addr, nil
+}
+
+// A relay that is uncounted when it closes, and that only exchanges packets with allowed peers.
+type countedPacketConn struct {
+	net.PacketConn
+	nucleus *types.Nucleus
+	once    sync.Once
+}
+
+// Relayed packets to refused peers are dropped.
+func (c *countedPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if !relayPeerAllowed(c.nucleus, addr) {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
+
+// Packets from refused peers are dropped.
+func (c *countedPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || relayPeerAllowed(c.nucleus, addr) {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *countedPacketConn) Close() error {
+	c.once.Do(func() {
+		updateTURNStats(c.nucleus, func(stats *types.TURNStats) { stats.Allocations-- })
+	})
+	return c.PacketConn.Close()
+}
+
+// Apply a change to the TURN stats and publish them on the stats channel.
+func updateTURNStats(nucleus *types.Nucleus, update func(stats *types.TURNStats)) {
+	nucleus.TURN.Mutex.Lock()
+	update(nucleus.TURN)
+	statsMarshaled, err := json.Marshal(nucleus.TURN)
+	nucleus.TURN.Mutex.Unlock()
+
+	if err != nil {
+		log.Printf("Error marshaling TURN stats: %s", err)
+		return
+	}
+
+	// Stats are only read while someone is watching, drop them rather than block.
+	select {
+	case nucleus.Stats <- string(statsMarshaled):
+	default:
+	}
+}
@@ -0,0 +1,97 @@
+package modules
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/evanboardway/hiwave_go/types"
+	"github.com/google/uuid"
+)
+
+func TestTURNAuthHandler(t *testing.T) {
+	connected := uuid.New()
+	nucleus := &types.Nucleus{
+		Config:  &types.Config{TURNSecret: "secret"},
+		Clients: map[uuid.UUID]*types.Client{connected: {UUID: connected}},
+	}
+	handler := turnAuthHandler(nucleus)
+
+	future := time.Now().Add(time.Hour).Unix()
+	past := time.Now().Add(-time.Hour).Unix()
+
+	tests := []struct {
+		name     string
+		username string
+		ok       bool
+	}{
+		{"connected client", fmt.Sprintf("%d:%s", future, connected), true},
+		{"expired", fmt.Sprintf("%d:%s", past, connected), false},
+		{"disconnected client", fmt.Sprintf("%d:%s", future, uuid.New()), false},
+		{"no user", fmt.Sprintf("%d", future), false},
+		{"user isn't a uuid", fmt.Sprintf("%d:user", future), false},
+		{"expiry isn't a number", fmt.Sprintf("soon:%s", connected), false},
+		{"empty", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, ok := handler(test.username, "hiwave", &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 5000})
+			if ok != test.ok {
+				t.Fatalf("handler() ok = %t, expected %t", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+
+			// The long term credential key, MD5(username ":" realm ":" password).
+			expected := md5.Sum([]byte(test.username + ":hiwave:" + turnPassword("secret", test.username)))
+			if !bytes.Equal(key, expected[:]) {
+				t.Errorf("handler() key = %x, expected %x", key, expected)
+			}
+		})
+	}
+}
+
+func TestRelayPeerAllowed(t *testing.T) {
+	nucleus := &types.Nucleus{
+		Config: &types.Config{
+			TURNPublicIP: "198.51.100.7",
+			NAT1To1IPs:   []string{"198.51.100.8/10.0.0.8"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		addr    net.Addr
+		allowed bool
+	}{
+		{"public", &net.UDPAddr{IP: net.ParseIP("203.0.113.1"), Port: 5000}, true},
+		{"public IPv6", &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5000}, true},
+		{"loopback", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 5000}, false},
+		{"private", &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000}, false},
+		{"private IPv6", &net.UDPAddr{IP: net.ParseIP("fd00::1"), Port: 5000}, false},
+		{"start of 172.16.0.0/12", &net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 5000}, false},
+		{"end of 172.16.0.0/12", &net.UDPAddr{IP: net.ParseIP("172.31.255.254"), Port: 5000}, false},
+		{"just past 172.16.0.0/12", &net.UDPAddr{IP: net.ParseIP("172.32.0.1"), Port: 5000}, true},
+		{"IPv4 mapped private", &net.UDPAddr{IP: net.ParseIP("::ffff:10.1.2.3"), Port: 5000}, false},
+		{"link local", &net.UDPAddr{IP: net.ParseIP("169.254.169.254"), Port: 80}, false},
+		{"unspecified", &net.UDPAddr{IP: net.ParseIP("0.0.0.0"), Port: 5000}, false},
+		{"multicast", &net.UDPAddr{IP: net.ParseIP("224.0.0.1"), Port: 5000}, false},
+		{"server's public IP", &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 5000}, true},
+		{"server's mapped private IP", &net.UDPAddr{IP: net.ParseIP("10.0.0.8"), Port: 5000}, true},
+		{"other private IP", &net.UDPAddr{IP: net.ParseIP("10.0.0.9"), Port: 5000}, false},
+		{"not UDP", &net.TCPAddr{IP: net.ParseIP("203.0.113.1"), Port: 5000}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if allowed := relayPeerAllowed(nucleus, test.addr); allowed != test.allowed {
+				t.Errorf("relayPeerAllowed(%s) = %t, expected %t", test.addr, allowed, test.allowed)
+			}
+		})
+	}
+}
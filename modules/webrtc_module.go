@@ -1,56 +1,33 @@
 package modules
 
 import (
-	"encoding/json"
 	"fmt"
-	"log"
 
 	"github.com/evanboardway/hiwave_go/types"
 	"github.com/pion/webrtc/v3"
 )
 
-func createPeerConnection(client *types.Client) {
+var errNoPeerConnection = types.NewProtocolError(types.ERROR_NO_PEER_CONNECTION, "client has no peer connection")
+
+func createPeerConnection(client *types.Client) error {
 	// Configure ICE servers
 	config := webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: []string{"stun:stun.stunprotocol.org"},
-			},
-		},
+		ICEServers: iceServers(client.Nucleus, client.UUID.String()),
+	}
+
+	api, err := newWebRTCAPI(client.Nucleus)
+	if err != nil {
+		return err
 	}
 
 	// Create new PeerConnection
-	peerConnection, err := webrtc.NewPeerConnection(config)
+	peerConnection, err := api.NewPeerConnection(config)
 	if err != nil {
-		log.Printf("%+v\n", err)
+		return err
 	}
 
 	peerConnection.OnNegotiationNeeded(func() {
-		// log.Printf("PC EVENT: renegotiation needed, %s \n", peerConnection.SignalingState())
-
-		if peerConnection.SignalingState() != webrtc.SignalingStateStable {
-			log.Println("Blocked renegotiation due to pending offer")
-			return
-		}
-
-		offer, err := peerConnection.CreateOffer(nil)
-		if err != nil {
-			log.Printf("Error renegotiating offer: %s", err)
-		}
-
-		if err = client.PeerConnection.SetLocalDescription(offer); err != nil {
-			log.Printf("Error setting local description: %s", err)
-		}
-
-		off, err := json.Marshal(offer)
-		if err != nil {
-			log.Printf("Error marshaling renegotiation offer: %s", err)
-		}
-
-		client.WriteChan <- &types.WebsocketMessage{
-			Event: "wrtc_renegotiation_needed",
-			Data:  string(off),
-		}
+		batchNegotiation(client, peerConnection)
 	})
 
 	// Trickle ICE handler
@@ -59,42 +36,48 @@ func createPeerConnection(client *types.Client) {
 			return
 		}
 
-		candidateString, err := json.Marshal(candidate.ToJSON())
-		if err != nil {
-			fmt.Printf("%+v\n", err)
-			return
-		}
-
 		client.WriteChan <- &types.WebsocketMessage{
-			Event: "wrtc_candidate",
-			Data:  string(candidateString),
+			Event:   "wrtc_candidate",
+			Payload: candidate.ToJSON(),
 		}
 	})
 
-	// If the peer connection fails...
+	// If the peer connection drops or fails, restart ICE before giving up on it.
 	peerConnection.OnICEConnectionStateChange(func(connectionState webrtc.ICEConnectionState) {
+		handleICEState(client, peerConnection, connectionState)
+	})
 
-		if connectionState == webrtc.ICEConnectionStateFailed {
-			client.WriteChan <- &types.WebsocketMessage{
-				Event: "wrtc_failed",
-			}
-			if closeErr := peerConnection.Close(); closeErr != nil {
-				log.Printf("Error closing the peer connection %s", closeErr)
-			}
+	// A dedicated track for join and leave cues. HTTP sessions only get the m-lines they offered.
+	var announcements *webrtc.TrackLocalStaticSample
+	if client.HTTPSession == nil {
+		announcements = addAnnouncementTrack(client, peerConnection)
+	}
 
-			log.Println("Peer connection state failed. Handling client disconnect")
-			handleDisconnect(client)
+	peerConnection.OnTrack(func(tr *webrtc.TrackRemote, r *webrtc.RTPReceiver) {
+		var audioLevelExtID uint8
+		for _, extension := range r.GetParameters().HeaderExtensions {
+			if extension.URI == AUDIO_LEVEL_URI {
+				audioLevelExtID = uint8(extension.ID)
+			}
 		}
-	})
 
-	peerConnection.OnTrack(func(tr *webrtc.TrackRemote, r *webrtc.RTPReceiver) {
+		client.SetInboundStream(uint32(tr.SSRC()), tr.Codec().MimeType, audioLevelExtID)
+		go readReceiverRTCP(client, r)
+
+		// Listeners registered before the codec was known may need another.
+		go reselectCodecs(client)
+
 		buff := make([]byte, 1500)
 		for {
 			i, _, err := tr.Read(buff)
 			if err != nil {
 				return
 			}
-			client.InboundAudio <- buff[:i]
+
+			// Copy the packet out since the buffer is reused for the next read.
+			packet := make([]byte, i)
+			copy(packet, buff[:i])
+			client.InboundAudio <- packet
 		}
 	})
 
@@ -103,101 +86,112 @@ func createPeerConnection(client *types.Client) {
 
 	client.PCMutex.Lock()
 	client.PeerConnection = peerConnection
+	client.Announcements = announcements
 	client.PCMutex.Unlock()
 
 	// Start locating and connecting to other clients.
 	go locateAndConnect(client)
-}
 
-func handleRenegotiation(client *types.Client, message *types.WebsocketMessage) {
+	// Start telling the client where the peers it can hear are.
+	go spatialUpdates(client)
 
-	remoteOffer := webrtc.SessionDescription{}
-	if err := json.Unmarshal([]byte(message.Data), &remoteOffer); err != nil {
-		log.Print(err)
-	}
+	return nil
+}
 
-	if client.PeerConnection.SignalingState() != webrtc.SignalingStateStable {
-		client.PeerConnection.SetLocalDescription(webrtc.SessionDescription{
-			Type: webrtc.SDPTypeRollback,
-		})
-		client.PeerConnection.SetRemoteDescription(remoteOffer)
-	}
+func handleRenegotiation(client *types.Client, message *types.WebsocketMessage) error {
 
-	if err := client.PeerConnection.SetRemoteDescription(remoteOffer); err != nil {
-		log.Printf("Error setting remote description: %s", err)
+	remoteOffer := webrtc.SessionDescription{}
+	if err := decodePayload(message, &remoteOffer); err != nil {
+		return err
 	}
 
-	answer, err := client.PeerConnection.CreateAnswer(nil)
-	if err != nil {
-		log.Printf("Error creating renegotiation answer %s", err)
+	peerConnection := currentPeerConnection(client)
+	if peerConnection == nil {
+		return errNoPeerConnection
 	}
 
-	if err = client.PeerConnection.SetLocalDescription(answer); err != nil {
-		log.Printf("Error setting local description: %s", err)
-	}
-
-	ans, _ := json.Marshal(answer)
-
-	client.WriteChan <- &types.WebsocketMessage{
-		Event: "wrtc_answer",
-		Data:  string(ans),
+	if err := answerOffer(client, peerConnection, remoteOffer); err != nil {
+		return err
 	}
 
+	replayNegotiation(client, peerConnection)
+	return nil
 }
 
-func handleIceCandidate(client *types.Client, message *types.WebsocketMessage) {
+func handleIceCandidate(client *types.Client, message *types.WebsocketMessage) error {
 	fmt.Printf("Candidate recvd: %+v", message)
 	candidate := webrtc.ICECandidateInit{}
-	if err := json.Unmarshal([]byte(message.Data), &candidate); err != nil {
-		fmt.Printf("%+v\n", err)
-		return
+	if err := decodePayload(message, &candidate); err != nil {
+		return err
 	}
 
-	if err := client.PeerConnection.AddICECandidate(candidate); err != nil {
-		fmt.Printf("%+v\n", err)
-		return
+	peerConnection := currentPeerConnection(client)
+	if peerConnection == nil {
+		return errNoPeerConnection
 	}
-}
 
-func handleOffer(client *types.Client, message *types.WebsocketMessage) {
+	if err := peerConnection.AddICECandidate(candidate); err != nil {
+		return types.NewProtocolError(types.ERROR_NEGOTIATION_FAILED, "adding ICE candidate: %s", err)
+	}
+	return nil
+}
 
-	createPeerConnection(client)
+func handleOffer(client *types.Client, message *types.WebsocketMessage) error {
 
 	offer := webrtc.SessionDescription{}
-	if err := json.Unmarshal([]byte(message.Data), &offer); err != nil {
-		log.Print(err)
+	if err := decodePayload(message, &offer); err != nil {
+		return err
 	}
 
-	if err := client.PeerConnection.SetRemoteDescription(offer); err != nil {
-		log.Printf("Error setting remote description: %s", err)
+	if err := createPeerConnection(client); err != nil {
+		return err
 	}
+	peerConnection := currentPeerConnection(client)
 
-	answer, err := client.PeerConnection.CreateAnswer(nil)
-	if err != nil {
-		log.Printf("Error creating answer: %s", err)
+	if err := answerOffer(client, peerConnection, offer); err != nil {
+		return err
 	}
 
-	if err = client.PeerConnection.SetLocalDescription(answer); err != nil {
-		log.Printf("Error setting local description: %s", err)
+	replayNegotiation(client, peerConnection)
+	return nil
+}
+
+func handleAnswer(client *types.Client, message *types.WebsocketMessage) error {
+	answer := webrtc.SessionDescription{}
+	if err := decodePayload(message, &answer); err != nil {
+		return err
 	}
 
-	ans, _ := json.Marshal(answer)
+	peerConnection := currentPeerConnection(client)
+	if peerConnection == nil {
+		return errNoPeerConnection
+	}
 
-	client.WriteChan <- &types.WebsocketMessage{
-		Event: "wrtc_answer",
-		Data:  string(ans),
+	if err := peerConnection.SetRemoteDescription(answer); err != nil {
+		return types.NewProtocolError(types.ERROR_NEGOTIATION_FAILED, "setting remote description: %s", err)
 	}
+	updateNegotiatedCodecs(client)
 
+	replayNegotiation(client, peerConnection)
+	return nil
 }
 
-func handleAnswer(client *types.Client, message *types.WebsocketMessage) {
-	answer := webrtc.SessionDescription{}
-	if err := json.Unmarshal([]byte(message.Data), &answer); err != nil {
-		log.Print(err)
+// Set the local answer to the offer the peer connection was just given.
+func createAnswer(peerConnection *webrtc.PeerConnection) (*webrtc.SessionDescription, error) {
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return nil, types.NewProtocolError(types.ERROR_NEGOTIATION_FAILED, "creating answer: %s", err)
 	}
 
-	if err := client.PeerConnection.SetRemoteDescription(answer); err != nil {
-		log.Printf("Error setting remote description: %s", err)
+	if err = peerConnection.SetLocalDescription(answer); err != nil {
+		return nil, types.NewProtocolError(types.ERROR_NEGOTIATION_FAILED, "setting local description: %s", err)
 	}
+	return &answer, nil
+}
 
+// The client's peer connection, nil if it hasn't connected.
+func currentPeerConnection(client *types.Client) *webrtc.PeerConnection {
+	client.PCMutex.RLock()
+	defer client.PCMutex.RUnlock()
+	return client.PeerConnection
 }
@@ -1,8 +1,33 @@
 package types
 
-import "github.com/pion/webrtc/v3"
+import (
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+)
 
 type AudioBundle struct {
 	Transceiver *webrtc.RTPTransceiver
 	Track       *webrtc.TrackLocalStaticRTP
+
+	// The listener's transceiver slot the track is sent on.
+	Slot *TransceiverSlot
+
+	// The client the track is sent to.
+	Listener *Client
+
+	// Converts the speaker's payloads to the track's codec, nil when they share a codec.
+	Transcode func(payload []byte) []byte
+
+	// Link quality from the listener's receiver reports, nil until the first report.
+	Stats *LinkStats
+
+	// Set when the listener's bandwidth can't carry the track, nothing is written to it.
+	Shed bool
+
+	// The share of the listener's bandwidth the track may use, zero when unconstrained.
+	Bitrate float64
+
+	// Mutex to lock the stats and shed state
+	StatsMutex sync.RWMutex
 }
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Estimate of the bandwidth available to send a client audio, from its congestion feedback.
+type BandwidthEstimate struct {
+	// Estimated bits per second the client can receive, zero until the first feedback
+	Bitrate float64
+
+	// The client's last REMB, zero if it doesn't send them
+	REMB float64
+
+	// Fraction of packets lost in the last feedback
+	LossRatio float64
+
+	// When the client's tracks were last checked against the estimate
+	Rebalanced time.Time
+
+	// The bitrate the client was last asked to send at, zero if it hasn't been limited
+	Requested float64
+
+	Mutex sync.Mutex
+}
+
+// Measures the rate bytes pass through it, averaged over a window.
+type BitrateMeter struct {
+	bytes       uint64
+	windowStart time.Time
+	bitrate     float64
+
+	Mutex sync.Mutex
+}
+
+var (
+	// How long the bitrate meter averages over
+	BITRATE_WINDOW = 2 * time.Second
+)
+
+func (m *BitrateMeter) Add(bytes int) {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	now := time.Now()
+	if m.windowStart.IsZero() {
+		m.windowStart = now
+	}
+
+	m.bytes += uint64(bytes)
+	if elapsed := now.Sub(m.windowStart); elapsed >= BITRATE_WINDOW {
+		m.bitrate = float64(m.bytes*8) / elapsed.Seconds()
+		m.bytes = 0
+		m.windowStart = now
+	}
+}
+
+// Bits per second over the last complete window.
+func (m *BitrateMeter) Bitrate() float64 {
+	m.Mutex.Lock()
+	defer m.Mutex.Unlock()
+
+	// Nothing has been sent for a whole window.
+	if time.Since(m.windowStart) > 2*BITRATE_WINDOW {
+		return 0
+	}
+	return m.bitrate
+}
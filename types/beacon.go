@@ -0,0 +1,30 @@
+package types
+
+import "sync"
+
+// How a beacon is described in the beacons file and the admin API.
+type BeaconConfig struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+
+	// Riders within this many meters of the beacon hear it
+	Radius float64
+
+	// Ogg Opus files played in order and looped
+	Files []string
+}
+
+// A virtual participant that plays audio files at a fixed location.
+type Beacon struct {
+	Config *BeaconConfig
+
+	// Closed to stop the beacon
+	Stop chan bool
+
+	// Closes Stop once, however many times the beacon is removed
+	End sync.Once
+
+	// Closed once the beacon has been removed from the nucleus
+	Stopped chan bool
+}
@@ -18,18 +18,74 @@ type Client struct {
 	// a pointer to the nucleus so that we can access its channels.
 	Nucleus *Nucleus
 
-	// Websocket connection
+	// Websocket connection, swapped when the client resumes
 	Socket *ThreadSafeWriter
 
+	// Resuming the client after its socket drops
+	Resume ResumeState
+
 	// Client IP address used to ensure one connection per ip.
 	IpAddr string
 
 	// A channel whose data is written to the websocket.
 	WriteChan chan *WebsocketMessage
 
+	// The signaling protocol version agreed in the client's hello
+	ProtocolVersion int
+
+	// A mutex to lock the protocol version
+	ProtocolMutex sync.RWMutex
+
 	// A track referencing audio packets being sent from the client.
 	InboundAudio chan []byte
 
+	// The SSRC of the audio the client sends, used to forward feedback from listeners.
+	InboundSSRC uint32
+
+	// The mime type of the audio the client sends
+	InboundCodec string
+
+	// The audio codecs (lower case mime types) the client can receive
+	Codecs map[string]bool
+
+	// Whether the client negotiated Opus RED, so its tracks carry redundancy.
+	RED bool
+
+	// The payload type the client mapped Opus to, which the blocks of RED payloads carry
+	OpusPT uint8
+
+	// Speakers the client has been told it can't receive (key) and the codec they sent
+	// (value). Forgotten when the client renegotiates.
+	UnsupportedCodecs map[uuid.UUID]string
+
+	// The negotiated id of the audio level header extension on the client's audio, 0 if not negotiated.
+	AudioLevelExtID uint8
+
+	// A mutex to lock the inbound stream and the negotiated codecs, which are set from
+	// pion's callbacks and read while routing audio
+	MediaMutex sync.RWMutex
+
+	// Silence suppressed from the client's audio
+	Silence SilenceState
+
+	// The rate the client's audio is forwarded at, per listener
+	Outbound BitrateMeter
+
+	// The bandwidth available to send the client audio
+	Bandwidth BandwidthEstimate
+
+	// Renegotiation of the client's peer connection
+	Negotiation NegotiationState
+
+	// Idle transceivers on the client's peer connection, reused for peers coming into range
+	IdleSlots []*TransceiverSlot
+
+	// Speakers that found no free transceiver on the client, skipped until one is released
+	AwaitingSlot map[uuid.UUID]bool
+
+	// A mutex to lock the idle transceivers and the speakers awaiting them
+	SlotsMutex sync.Mutex
+
 	// A channel to stop routing audio to peers
 	StopRoutingAudio chan bool
 
@@ -39,6 +95,9 @@ type Client struct {
 	// A chan to signal to the handleDisconnect when the locate and connect goroutine has been stopped
 	StoppedLAC chan bool
 
+	// A channel to stop sending spatial updates to the client
+	StopSpatial chan bool
+
 	// A channel to signal when the client has been removed from the nucleus
 	RemovedFromNucleus chan bool
 
@@ -56,6 +115,42 @@ type Client struct {
 
 	// A mutex to lock the registered clients list
 	RCMutex sync.RWMutex
+
+	// Audio from the client is not routed to anyone.
+	Muted bool
+
+	// No audio is routed to the client.
+	Deafened bool
+
+	// A set of peers whose audio is not routed to this client.
+	MutedPeers map[uuid.UUID]bool
+
+	// A mutex to lock the mute and deafen state
+	MuteMutex sync.RWMutex
+
+	// The recording of the client's inbound audio, nil when not recording.
+	Recording *Recording
+
+	// A mutex to lock the recording
+	RecMutex sync.Mutex
+
+	// Set when the client is a server side beacon rather than a connected user.
+	Beacon *Beacon
+
+	// Set when the client connected over WHIP or WHEP rather than the websocket.
+	HTTPSession *HTTPSession
+
+	// A track the server plays audio prompts to the client on, guarded by PCMutex.
+	Announcements *webrtc.TrackLocalStaticSample
+
+	// A mutex so that only one prompt plays at a time
+	AnnounceMutex sync.Mutex
+
+	// The loopback diagnostic in progress, nil when there isn't one.
+	Diagnostic *Diagnostic
+
+	// A mutex to lock the diagnostic
+	DiagMutex sync.Mutex
 }
 
 func NewClient(safeConn *ThreadSafeWriter, nucleus *Nucleus, remoteAddress string) *Client {
@@ -68,11 +163,113 @@ func NewClient(safeConn *ThreadSafeWriter, nucleus *Nucleus, remoteAddress strin
 		Socket:             safeConn,
 		IpAddr:             remoteAddress,
 		WriteChan:          make(chan *WebsocketMessage),
+		ProtocolVersion:    MIN_PROTOCOL_VERSION,
 		StopRoutingAudio:   make(chan bool),
 		StopLAC:            make(chan bool),
 		RemovedFromNucleus: make(chan bool),
 		StoppedLAC:         make(chan bool),
+		StopSpatial:        make(chan bool),
 		RegisteredClients:  make(map[uuid.UUID]*AudioBundle),
 		InboundAudio:       make(chan []byte, 1500),
+		MutedPeers:         make(map[uuid.UUID]bool),
+		Codecs:             make(map[string]bool),
+		AwaitingSlot:       make(map[uuid.UUID]bool),
+		Resume:             ResumeState{Token: NewToken(), Ended: make(chan bool)},
+	}
+}
+
+func (c *Client) IsMuted() bool {
+	c.MuteMutex.RLock()
+	defer c.MuteMutex.RUnlock()
+	return c.Muted
+}
+
+// The SSRC and mime type of the audio the client sends, zero until it arrives.
+func (c *Client) InboundStream() (uint32, string) {
+	c.MediaMutex.RLock()
+	defer c.MediaMutex.RUnlock()
+	return c.InboundSSRC, c.InboundCodec
+}
+
+func (c *Client) SetInboundStream(ssrc uint32, codec string, audioLevelExtID uint8) {
+	c.MediaMutex.Lock()
+	defer c.MediaMutex.Unlock()
+	c.InboundSSRC = ssrc
+	c.InboundCodec = codec
+	c.AudioLevelExtID = audioLevelExtID
+}
+
+func (c *Client) AudioLevelExtension() uint8 {
+	c.MediaMutex.RLock()
+	defer c.MediaMutex.RUnlock()
+	return c.AudioLevelExtID
+}
+
+// The codecs the client can receive and whether they include RED. The map isn't modified
+// once set, it is replaced when the client renegotiates.
+func (c *Client) ReceiveCodecs() (map[string]bool, bool) {
+	c.MediaMutex.RLock()
+	defer c.MediaMutex.RUnlock()
+	return c.Codecs, c.RED
+}
+
+func (c *Client) SetReceiveCodecs(codecs map[string]bool, red bool, opusPayloadType uint8) {
+	c.MediaMutex.Lock()
+	defer c.MediaMutex.Unlock()
+	c.Codecs = codecs
+	c.RED = red
+	c.OpusPT = opusPayloadType
+	c.UnsupportedCodecs = nil
+}
+
+// Note that the client can't receive the speaker's codec. Returns false if the client has
+// already been told since it last negotiated.
+func (c *Client) ReportUnsupportedCodec(speaker uuid.UUID, codec string) bool {
+	c.MediaMutex.Lock()
+	defer c.MediaMutex.Unlock()
+
+	if c.UnsupportedCodecs[speaker] == codec {
+		return false
 	}
+	if c.UnsupportedCodecs == nil {
+		c.UnsupportedCodecs = make(map[uuid.UUID]string)
+	}
+	c.UnsupportedCodecs[speaker] = codec
+	return true
+}
+
+func (c *Client) OpusPayloadType() uint8 {
+	c.MediaMutex.RLock()
+	defer c.MediaMutex.RUnlock()
+	return c.OpusPT
+}
+
+func (c *Client) IsDeafened() bool {
+	c.MuteMutex.RLock()
+	defer c.MuteMutex.RUnlock()
+	return c.Deafened
+}
+
+// Whether the client's audio is routed to peers. WHEP sessions only listen.
+func (c *Client) Publishes() bool {
+	return c.HTTPSession == nil || c.HTTPSession.Kind == HTTP_SESSION_WHIP
+}
+
+// Whether the client is sent peers' audio. WHIP sessions only publish.
+func (c *Client) Listens() bool {
+	return c.HTTPSession == nil || c.HTTPSession.Kind == HTTP_SESSION_WHEP
+}
+
+// Whether the client has muted the peer for themselves.
+func (c *Client) HasMuted(peerUUID uuid.UUID) bool {
+	c.MuteMutex.RLock()
+	defer c.MuteMutex.RUnlock()
+	return c.MutedPeers[peerUUID]
+}
+
+// The signaling protocol version the client speaks.
+func (c *Client) Version() int {
+	c.ProtocolMutex.RLock()
+	defer c.ProtocolMutex.RUnlock()
+	return c.ProtocolVersion
 }
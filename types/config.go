@@ -0,0 +1,103 @@
+package types
+
+import "time"
+
+// Server wide settings supplied at startup.
+type Config struct {
+	// Only clients holding the floor of their proximity group have their audio routed.
+	PushToTalk bool
+
+	// The longest a client may hold the floor before it is released for them.
+	FloorMaxTalkTime time.Duration
+
+	// Drop silent packets instead of forwarding them to listeners.
+	SilenceSuppression bool
+
+	// Audio quieter than this many dB below full scale counts as silence.
+	SilenceLevel int
+
+	// How often a silent packet is still forwarded to keep listeners' streams alive.
+	SilenceKeepalive time.Duration
+
+	// Listeners reporting at least this much loss (percent) are sent redundant audio.
+	REDLossThreshold float64
+
+	// Whether the server gives way when its offer collides with a client's. Clients take the other role.
+	PoliteServer bool
+
+	// How long track changes are collected before a client is sent one offer for all of them.
+	NegotiationWindow time.Duration
+
+	// How many idle transceivers each client keeps for reuse, more are stopped.
+	TransceiverPoolSize int
+
+	// STUN (or statically authenticated TURN) server URLs used by the server and handed to clients.
+	ICEServers []string
+
+	// TURN server URLs that are handed out with time limited credentials.
+	TURNServers []string
+
+	// Secret shared with the TURN servers, credentials are derived from it.
+	TURNSecret string
+
+	// How long issued TURN credentials stay valid.
+	TURNCredentialTTL time.Duration
+
+	// Single UDP port every peer connection's ICE traffic is muxed over, zero for a port per connection.
+	ICEUDPPort int
+
+	// TCP port ICE TCP candidates are muxed over, zero to disable ICE over TCP.
+	ICETCPPort int
+
+	// Public IPs advertised in place of the host's when it sits behind a 1:1 NAT.
+	NAT1To1IPs []string
+
+	// Whether the NAT IPs replace the host candidates ("host") or are added as server reflexive ("srflx").
+	NAT1To1CandidateType string
+
+	// Network interfaces ICE gathers candidates on, all of them when empty.
+	ICEInterfaces []string
+
+	// Address the embedded TURN server listens on (UDP). The server only runs when set.
+	TURNListen string
+
+	// The public IP relays are advertised on and clients reach the embedded TURN server at.
+	TURNPublicIP string
+
+	// Realm of the embedded TURN server
+	TURNRealm string
+
+	// Range of ports the embedded TURN server allocates relays from.
+	TURNRelayMinPort int
+	TURNRelayMaxPort int
+
+	// How long a disconnected ICE connection is given to recover before it is restarted.
+	ICERestartDelay time.Duration
+
+	// ICE restarts attempted before a client's peer connection is torn down.
+	ICERestartAttempts int
+
+	// How long a client whose websocket dropped is kept for a new socket to resume it, 0 to never.
+	ResumeGrace time.Duration
+
+	// Estimate each listener's bandwidth and shed or slow peers that don't fit.
+	BandwidthEstimation bool
+
+	// Directory recordings are written to
+	RecordingDir string
+
+	// How long recordings are kept before being deleted. Zero keeps them forever.
+	RecordingRetention time.Duration
+
+	// JSON file listing the beacons to start with the server
+	BeaconsFile string
+
+	// Directory holding the joined.ogg, left.ogg and floor_granted.ogg prompts
+	PromptsDir string
+
+	// Token required by the admin API. The admin API is disabled when empty.
+	AdminToken string
+
+	// Token WHIP and WHEP clients must bear. WHIP and WHEP are disabled when empty.
+	HTTPSessionToken string
+}
@@ -0,0 +1,117 @@
+package types
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// A recording of every participant in a proximity cluster.
+type Conversation struct {
+	ID uuid.UUID
+
+	// The client that started the recording. The cluster is found starting from them.
+	Initiator *Client
+
+	// Directory the participant tracks, mixed file and sidecar are written to
+	Dir string
+
+	// The mixed file, relative to Dir, once the participant tracks have been mixed
+	File string
+
+	Started time.Time
+
+	// A map of client uuid (key) to their track in the conversation (value).
+	Participants map[uuid.UUID]*ConversationTrack
+
+	// Clients that asked not to be recorded, they aren't recorded even if they rejoin.
+	OptedOut map[uuid.UUID]bool
+
+	// Joins and leaves in the order they happened
+	Events []*ConversationEvent
+
+	// A channel to stop following the cluster
+	Stop chan bool
+
+	// Mutex to lock the participants and events
+	Mutex sync.Mutex
+}
+
+// A single participant's audio within a conversation.
+type ConversationTrack struct {
+	Writer *oggwriter.OggWriter
+
+	// The file the participant's audio is written to until it is mixed, relative to the
+	// conversation directory
+	File string
+
+	// The participant's logical stream in the mixed file, 0 until it is mixed
+	Serial uint32
+
+	// Whether the participant is currently in the cluster
+	Active bool
+
+	// Time from the start of the conversation to the participant's first packet.
+	// Later packets are placed using their RTP timestamps relative to the first.
+	Offset time.Duration
+
+	// Whether a packet has been written yet
+	Started bool
+}
+
+// A participant joining or leaving the cluster.
+type ConversationEvent struct {
+	UUID uuid.UUID
+
+	// One of "joined" or "left"
+	Event string
+
+	Time time.Time
+
+	// Milliseconds since the start of the conversation
+	OffsetMs int64
+
+	Location *LocationData
+}
+
+// The JSON sidecar written alongside a conversation recording.
+type ConversationSidecar struct {
+	ID        uuid.UUID
+	Initiator uuid.UUID
+	Started   time.Time
+	Stopped   *time.Time `json:",omitempty"`
+
+	// The Ogg Opus file holding every participant's audio as its own logical stream, time
+	// aligned by granule position. Empty until the conversation stops.
+	File string `json:",omitempty"`
+
+	Participants []*ConversationParticipant
+	Events       []*ConversationEvent
+}
+
+type ConversationParticipant struct {
+	UUID uuid.UUID
+
+	// The serial number of the participant's logical stream in the file, 0 when the
+	// participant wasn't heard
+	Serial uint32 `json:",omitempty"`
+
+	// Milliseconds from the start of the conversation to the participant's first packet
+	OffsetMs int64
+}
+
+// The data sent with conversation_opt_out, the conversation the client leaves.
+type ConversationOptOut struct {
+	ID uuid.UUID
+}
+
+// The data sent with conversation_recording and conversation_recording_stopped events.
+// Peers sent conversation_recording can opt out with its ID.
+type ConversationState struct {
+	ID        uuid.UUID
+	Initiator uuid.UUID
+	Started   time.Time
+	Stopped   *time.Time `json:",omitempty"`
+}
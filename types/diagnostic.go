@@ -0,0 +1,55 @@
+package types
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// A loopback radio check. The client's audio is echoed back on a dedicated track while
+// the quality of both legs is measured.
+type Diagnostic struct {
+	Track       *webrtc.TrackLocalStaticRTP
+	Transceiver *webrtc.RTPTransceiver
+
+	Started time.Time
+
+	// RTP clock rate of the echoed codec, which the downlink jitter is reported in
+	ClockRate uint32
+
+	// Loss and jitter of the audio the client sends the server
+	Uplink *StreamStats
+
+	// Round trip times taken from the client's receiver reports of the echo track
+	RoundTrips []time.Duration
+
+	// The client's last receiver report of the echo track
+	DownlinkFractionLost uint8
+	DownlinkTotalLost    uint32
+	DownlinkJitter       uint32
+
+	// Ends the diagnostic once it has run for long enough
+	Timer *time.Timer
+
+	// Mutex to lock the downlink measurements
+	Mutex sync.Mutex
+}
+
+// The data sent with diagnostic_result events.
+type DiagnosticResult struct {
+	DurationMs int64
+
+	UplinkPacketsReceived uint64
+	UplinkPacketsLost     int64
+	UplinkLossPercent     float64
+	UplinkJitterMs        float64
+
+	DownlinkPacketsLost uint32
+	DownlinkLossPercent float64
+	DownlinkJitterMs    float64
+
+	// Average round trip time, zero when the client sent no usable receiver reports
+	RoundTripMs      float64
+	RoundTripSamples int
+}
@@ -0,0 +1,63 @@
+package types
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Push to talk floor control. At most one client in a proximity group holds the floor at a time.
+type Floor struct {
+	// A map of client uuid (key) to the clients current grant (value).
+	Holders map[uuid.UUID]*FloorGrant
+
+	// Clients waiting for the floor in the order they asked for it.
+	Queue []*Client
+
+	// Counts the grants made, so a timer can tell whether its grant is still current
+	Generation uint64
+
+	// Mutex to lock the holders and the queue
+	Mutex sync.Mutex
+}
+
+type FloorGrant struct {
+	Client *Client
+
+	// When the floor was granted
+	Granted time.Time
+
+	// The floor's generation when the grant was made
+	Generation uint64
+
+	// Releases the floor once the max talk time has elapsed
+	Timer *time.Timer
+}
+
+// The data sent with floor_state events.
+type FloorState struct {
+	// The client the state change is about
+	UUID uuid.UUID
+
+	// One of "granted", "released" or "queued"
+	State string
+
+	// Position in the queue when the state is "queued"
+	Position int
+}
+
+func NewFloor() *Floor {
+	return &Floor{
+		Holders: make(map[uuid.UUID]*FloorGrant),
+		Queue:   make([]*Client, 0),
+	}
+}
+
+// Whether the client currently holds the floor.
+func (f *Floor) Holds(clientUUID uuid.UUID) bool {
+	f.Mutex.Lock()
+	defer f.Mutex.Unlock()
+
+	return f.Holders[clientUUID] != nil
+}
@@ -0,0 +1,28 @@
+package types
+
+import "sync"
+
+const (
+	// Publishes audio over WHIP, is never sent audio
+	HTTP_SESSION_WHIP = "whip"
+
+	// Listens over WHEP, its audio (if any) is never routed
+	HTTP_SESSION_WHEP = "whep"
+)
+
+// A client that signals with a single HTTP offer and answer rather than the websocket.
+// Its peer connection can't be renegotiated.
+type HTTPSession struct {
+	// HTTP_SESSION_WHIP or HTTP_SESSION_WHEP
+	Kind string
+
+	// The id of the session's resource. Unlike the client's uuid it isn't sent to peers,
+	// so only whoever created the session can end or move it.
+	Resource string
+
+	// Closed once the session has ended, nothing can write to the client after
+	Stopped chan bool
+
+	// Ends the session once, whether it is deleted or its connection fails
+	End sync.Once
+}
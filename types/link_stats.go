@@ -0,0 +1,20 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Quality of the link carrying one client's audio to another, taken from the listener's receiver reports.
+type LinkStats struct {
+	Speaker  uuid.UUID
+	Listener uuid.UUID
+
+	LossPercent float64
+	TotalLost   uint32
+	JitterMs    float64
+
+	// When the last receiver report arrived
+	Updated time.Time
+}
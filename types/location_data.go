@@ -9,6 +9,9 @@ import (
 var (
 	// 1/3 mile in terms of geographical coordinates
 	ONE_THIRD_MILE = 0.00483091787
+
+	// Mean radius of the earth in meters
+	EARTH_RADIUS = 6371000.0
 )
 
 type LocationData struct {
@@ -27,6 +30,69 @@ type LocationBundle struct {
 	Avatar   string
 }
 
+// Spatial information about a peer that a listener can hear.
+type SpatialData struct {
+	UUID     uuid.UUID
+	StreamID string
+	// The m-line the peer's audio arrives on, which outlives the stream id when transceivers are reused
+	Mid string
+	// Distance from the listener in meters
+	Distance float64
+	// Bearing to the peer in degrees, relative to the listener's heading (-180, 180]
+	Bearing float64
+}
+
+// Clients that haven't shared a location (such as HTTP sessions without one) are out of range.
 func WithinRange(from *LocationData, to *LocationData) bool {
+	if from == nil || to == nil {
+		return false
+	}
 	return math.Sqrt(math.Pow((to.Latitude-from.Latitude), 2)+math.Pow((to.Longitude-from.Longitude), 2)) <= ONE_THIRD_MILE
 }
+
+// Great circle distance between two locations in meters (haversine).
+func Distance(from *LocationData, to *LocationData) float64 {
+	fromLat := toRadians(from.Latitude)
+	toLat := toRadians(to.Latitude)
+	deltaLat := toRadians(to.Latitude - from.Latitude)
+	deltaLon := toRadians(to.Longitude - from.Longitude)
+
+	a := math.Pow(math.Sin(deltaLat/2), 2) + math.Cos(fromLat)*math.Cos(toLat)*math.Pow(math.Sin(deltaLon/2), 2)
+	return EARTH_RADIUS * 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// Initial bearing from one location to another in degrees clockwise from true north [0, 360).
+func Bearing(from *LocationData, to *LocationData) float64 {
+	fromLat := toRadians(from.Latitude)
+	toLat := toRadians(to.Latitude)
+	deltaLon := toRadians(to.Longitude - from.Longitude)
+
+	y := math.Sin(deltaLon) * math.Cos(toLat)
+	x := math.Cos(fromLat)*math.Sin(toLat) - math.Sin(fromLat)*math.Cos(toLat)*math.Cos(deltaLon)
+	return math.Mod(toDegrees(math.Atan2(y, x))+360, 360)
+}
+
+// Bearing to a location relative to the heading at another, normalized to (-180, 180].
+// A heading that is not a number (not reported by the device) is treated as north.
+func RelativeBearing(from *LocationData, to *LocationData) float64 {
+	heading := from.Heading
+	if math.IsNaN(heading) || heading < 0 {
+		heading = 0
+	}
+
+	relative := math.Mod(Bearing(from, to)-heading, 360)
+	if relative <= -180 {
+		relative += 360
+	} else if relative > 180 {
+		relative -= 360
+	}
+	return relative
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func toDegrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
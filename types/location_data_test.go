@@ -0,0 +1,64 @@
+package types
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name     string
+		from     *LocationData
+		to       *LocationData
+		expected float64
+	}{
+		{"same place", &LocationData{Latitude: 40, Longitude: -105}, &LocationData{Latitude: 40, Longitude: -105}, 0},
+		{"one degree of latitude", &LocationData{Latitude: 0, Longitude: 0}, &LocationData{Latitude: 1, Longitude: 0}, 111194.9},
+		{"one degree of longitude at the equator", &LocationData{Latitude: 0, Longitude: 0}, &LocationData{Latitude: 0, Longitude: 1}, 111194.9},
+		{"across the antimeridian", &LocationData{Latitude: 0, Longitude: 179.5}, &LocationData{Latitude: 0, Longitude: -179.5}, 111194.9},
+		{"pole to pole", &LocationData{Latitude: 90, Longitude: 0}, &LocationData{Latitude: -90, Longitude: 0}, math.Pi * EARTH_RADIUS},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if distance := Distance(test.from, test.to); math.Abs(distance-test.expected) > 1 {
+				t.Errorf("Distance() = %f, expected %f", distance, test.expected)
+			}
+		})
+	}
+}
+
+func TestRelativeBearing(t *testing.T) {
+	origin := func(heading float64) *LocationData {
+		return &LocationData{Latitude: 0, Longitude: 0, Heading: heading}
+	}
+	north := &LocationData{Latitude: 0.001, Longitude: 0}
+	east := &LocationData{Latitude: 0, Longitude: 0.001}
+	south := &LocationData{Latitude: -0.001, Longitude: 0}
+	west := &LocationData{Latitude: 0, Longitude: -0.001}
+
+	tests := []struct {
+		name     string
+		from     *LocationData
+		to       *LocationData
+		expected float64
+	}{
+		{"ahead", origin(0), north, 0},
+		{"to the right", origin(0), east, 90},
+		{"behind is 180 rather than -180", origin(0), south, 180},
+		{"to the left", origin(0), west, -90},
+		{"facing east, north is to the left", origin(90), north, -90},
+		{"facing west, north is to the right", origin(270), north, 90},
+		{"facing south, west is to the right", origin(180), west, 90},
+		{"unreported heading is north", origin(math.NaN()), east, 90},
+		{"negative heading is north", origin(-1), east, 90},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if bearing := RelativeBearing(test.from, test.to); math.Abs(bearing-test.expected) > 1e-6 {
+				t.Errorf("RelativeBearing() = %f, expected %f", bearing, test.expected)
+			}
+		})
+	}
+}
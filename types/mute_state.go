@@ -0,0 +1,13 @@
+package types
+
+import "github.com/google/uuid"
+
+// The data sent with peer_mute_state events.
+type MuteState struct {
+	UUID     uuid.UUID
+	Muted    bool
+	Deafened bool
+
+	// Only sent to the client the state belongs to.
+	MutedPeers []uuid.UUID `json:",omitempty"`
+}
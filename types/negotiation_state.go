@@ -0,0 +1,28 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracks renegotiation of a client's peer connection so that offers from both sides converge.
+type NegotiationState struct {
+	// Whether a renegotiation was needed while an offer was outstanding, it is replayed
+	// once signaling is stable again.
+	Pending bool
+
+	// Fires at the end of the window renegotiations are batched in, nil when none is waiting.
+	Batch *time.Timer
+
+	// Whether the next offer should restart ICE
+	RestartICE bool
+
+	// ICE restarts attempted since the connection was last up
+	Restarts int
+
+	// Fires when a disconnected connection has had long enough to recover by itself
+	RestartTimer *time.Timer
+
+	// Held while an offer is created or answered
+	Mutex sync.Mutex
+}
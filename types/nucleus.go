@@ -5,12 +5,17 @@ import (
 	"sync"
 
 	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+	"github.com/pion/webrtc/v3/pkg/media"
 )
 
 // The nucleus is the place where each client is stored.
 // The nucleus can write to each of the clients through their channels.
 
 type Nucleus struct {
+	// Identifies this run of the server, used to name recordings.
+	SessionID uuid.UUID
+
 	// A channel to append clients to the nucleus
 	Subscribe chan *Client
 
@@ -25,15 +30,58 @@ type Nucleus struct {
 
 	// Mutex to make sub and unsub chans one user only
 	Mutex sync.RWMutex
+
+	// Settings the server was started with
+	Config *Config
+
+	// Push to talk floor control
+	Floor *Floor
+
+	// A map of the blocker's uuid (key) to the uuids they have blocked (value). The uuid is
+	// kept when a client resumes, so blocks last exactly as long as the client does.
+	Blocks map[uuid.UUID]map[uuid.UUID]bool
+
+	// Mutex to lock the block lists
+	BlocksMutex sync.RWMutex
+
+	// A map of conversation id (key) to conversations being recorded (value).
+	Conversations map[uuid.UUID]*Conversation
+
+	// Mutex to lock the conversations map
+	ConversationsMutex sync.RWMutex
+
+	// A map of prompt name (key) to the prompt's Opus frames (value). Loaded at startup.
+	Prompts map[string][]media.Sample
+
+	// Allocations on the embedded TURN server, nil when it isn't running.
+	TURN *TURNStats
+
+	// ICE settings shared by every peer connection, including the single port muxes.
+	SettingEngine webrtc.SettingEngine
 }
 
 // Create a nucleus and return a pointer to it.
-func CreateNucleus() *Nucleus {
+func CreateNucleus(config *Config) *Nucleus {
 	log.Printf("New Nucleus")
 	return &Nucleus{
+		SessionID:   uuid.New(),
 		Subscribe:   make(chan *Client),
 		Unsubscribe: make(chan *Client),
 		Stats:       make(chan string, 1024),
 		Clients:     make(map[uuid.UUID]*Client),
+		Config:      config,
+		Floor:       NewFloor(),
+		Blocks:      make(map[uuid.UUID]map[uuid.UUID]bool),
+
+		Conversations: make(map[uuid.UUID]*Conversation),
+		Prompts:       make(map[string][]media.Sample),
 	}
 }
+
+// Whether either of the two users has blocked the other.
+func (n *Nucleus) IsBlocked(a *Client, b *Client) bool {
+	n.BlocksMutex.RLock()
+	defer n.BlocksMutex.RUnlock()
+
+	return n.Blocks[a.UUID][b.UUID] || n.Blocks[b.UUID][a.UUID]
+}
@@ -0,0 +1,96 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// The signaling protocol version the server speaks. Clients that never send a hello are
+	// treated as version 1, where data is always a JSON encoded string.
+	PROTOCOL_VERSION     = 2
+	MIN_PROTOCOL_VERSION = 1
+)
+
+// Codes sent with error events.
+const (
+	ERROR_INVALID_MESSAGE     = "invalid_message"
+	ERROR_INVALID_PAYLOAD     = "invalid_payload"
+	ERROR_UNKNOWN_EVENT       = "unknown_event"
+	ERROR_UNSUPPORTED_VERSION = "unsupported_version"
+	ERROR_NO_PEER_CONNECTION  = "no_peer_connection"
+	ERROR_NEGOTIATION_FAILED  = "negotiation_failed"
+	ERROR_GLARE               = "glare"
+	ERROR_INVALID_STATE       = "invalid_state"
+	ERROR_UNSUPPORTED_CODEC   = "unsupported_codec"
+	ERROR_INTERNAL            = "internal"
+)
+
+// An error a handler wants the client to see, sent as an error event.
+type ProtocolError struct {
+	Code    string
+	Message string
+}
+
+func NewProtocolError(code string, format string, args ...interface{}) *ProtocolError {
+	return &ProtocolError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+func (e *ProtocolError) Error() string {
+	return e.Message
+}
+
+// The data sent with hello events, in both directions.
+type HelloPayload struct {
+	// The version the client speaks, or the version the server settled on
+	Version int
+
+	// Only sent by the server, the client's identifier
+	UUID uuid.UUID `json:",omitempty"`
+
+	// Only sent by the server, whether it rolls back its offer when offers collide
+	Polite bool `json:",omitempty"`
+}
+
+// The data sent with error events.
+type ErrorPayload struct {
+	Code    string
+	Message string
+
+	// The event that failed, empty if the message couldn't be read
+	Event string `json:",omitempty"`
+
+	// The peer the error is about, e.g. the speaker of unsupported_codec
+	Peer *uuid.UUID `json:",omitempty"`
+}
+
+// The data sent with ack events.
+type AckPayload struct {
+	// The event that was applied
+	Event string
+}
+
+// The data sent with events about a single peer, by clients (mute_peer, block_user and their
+// inverses) and by the server (wrtc_remove_stream, peer_disconnected, peer_shed and
+// peer_restored). Version 1 clients send and get the peer's uuid on its own.
+type PeerPayload struct {
+	UUID uuid.UUID
+}
+
+// States sent with peer events.
+const (
+	PEER_CONNECTED    = "connected"
+	PEER_DISCONNECTED = "disconnected"
+)
+
+// The data sent with peer events. Version 1 clients get the state and peer as one string.
+type PeerStatePayload struct {
+	UUID  uuid.UUID
+	State string
+}
+
+// The data sent with set_current_avatar. Version 1 clients send the avatar on its own.
+type AvatarPayload struct {
+	Avatar string
+}
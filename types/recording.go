@@ -0,0 +1,26 @@
+package types
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3/pkg/media/oggwriter"
+)
+
+// An in progress recording of a client's inbound audio.
+type Recording struct {
+	Writer *oggwriter.OggWriter
+
+	// The file the recording is written to
+	Path string
+
+	Started time.Time
+}
+
+// The data sent with recording_started and recording_stopped events.
+type RecordingState struct {
+	UUID    uuid.UUID
+	File    string
+	Started time.Time
+	Stopped *time.Time `json:",omitempty"`
+}
@@ -0,0 +1,46 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Keeps a client whose websocket dropped alive for a grace period, so that a socket
+// presenting its token can take it over.
+type ResumeState struct {
+	// Presented by a reconnecting socket to reattach to the client, changed on every resume
+	Token string
+
+	// Whether the client's socket has dropped and it is waiting to be resumed
+	Suspended bool
+
+	// Messages written while the client had no socket, sent once it resumes
+	Backlog []*WebsocketMessage
+
+	// Whether a resume is still writing the backlog, messages are backlogged until it's done
+	Flushing bool
+
+	// Ends the client when the grace period runs out, nil while it is connected
+	Expiry *time.Timer
+
+	// Closed once the client has been shut down for good
+	Ended chan bool
+
+	// Ends the client once, whichever of its socket or grace period goes first
+	End sync.Once
+
+	// A mutex to lock the socket and resume state
+	Mutex sync.Mutex
+}
+
+// Sent when a websocket connects or resumes, with the token to resume the client with.
+type SessionPayload struct {
+	UUID        string
+	ResumeToken string
+
+	// Seconds the client is kept once its socket drops, 0 when sessions can't be resumed
+	ResumeGrace int
+
+	// Whether the socket reattached to an existing client
+	Resumed bool
+}
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracks the silence suppressed from a client's audio.
+type SilenceState struct {
+	// Whether the last packet received was silence
+	Silent bool
+
+	// When a packet was last forwarded
+	LastForwarded time.Time
+
+	// Packets dropped so far. Forwarded sequence numbers are shifted down by this so
+	// that listeners see a contiguous stream.
+	SeqOffset uint16
+
+	Mutex sync.Mutex
+}
@@ -0,0 +1,103 @@
+package types
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// Loss and interarrival jitter of an inbound RTP stream, calculated as in RFC 3550 appendix A.
+type StreamStats struct {
+	// RTP timestamp units per second
+	ClockRate uint32
+
+	// Packets received
+	Received uint64
+
+	started      bool
+	firstArrival time.Time
+	baseSeq      uint16
+	maxSeq       uint16
+	cycles       uint32
+	lastTransit  uint32
+	jitter       float64
+
+	Mutex sync.Mutex
+}
+
+func NewStreamStats(clockRate uint32) *StreamStats {
+	return &StreamStats{ClockRate: clockRate}
+}
+
+// Account for a packet that arrived at the given time.
+func (s *StreamStats) Update(header *rtp.Header, arrival time.Time) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if !s.started {
+		s.firstArrival = arrival
+	}
+
+	// Arrival in timestamp units since the first packet. Transit times, like RTP timestamps,
+	// wrap around, only the difference between two of them is meaningful.
+	elapsed := arrival.Sub(s.firstArrival)
+	units := int64(elapsed/time.Second)*int64(s.ClockRate) + int64(elapsed%time.Second)*int64(s.ClockRate)/int64(time.Second)
+	transit := uint32(units) - header.Timestamp
+
+	if !s.started {
+		s.started = true
+		s.baseSeq = header.SequenceNumber
+		s.maxSeq = header.SequenceNumber
+		s.lastTransit = transit
+		s.Received++
+		return
+	}
+
+	// A sequence number that moved forward (allowing for wrap around) is the new highest.
+	if delta := header.SequenceNumber - s.maxSeq; delta != 0 && delta < 1<<15 {
+		if header.SequenceNumber < s.maxSeq {
+			s.cycles++
+		}
+		s.maxSeq = header.SequenceNumber
+	}
+
+	d := int32(transit - s.lastTransit)
+	if d < 0 {
+		d = -d
+	}
+	s.lastTransit = transit
+	s.jitter += (float64(d) - s.jitter) / 16
+
+	s.Received++
+}
+
+// Packets expected from the first and highest sequence numbers seen.
+func (s *StreamStats) Expected() uint64 {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if !s.started {
+		return 0
+	}
+	return uint64(s.cycles)<<16 + uint64(s.maxSeq) - uint64(s.baseSeq) + 1
+}
+
+// Packets that never arrived. Negative when duplicates were received.
+func (s *StreamStats) Lost() int64 {
+	expected := s.Expected()
+
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return int64(expected) - int64(s.Received)
+}
+
+func (s *StreamStats) Jitter() time.Duration {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+
+	if s.ClockRate == 0 {
+		return 0
+	}
+	return time.Duration(s.jitter * float64(time.Second) / float64(s.ClockRate))
+}
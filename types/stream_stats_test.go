@@ -0,0 +1,55 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+func TestStreamStatsUpdate(t *testing.T) {
+	type packet struct {
+		seq       uint16
+		timestamp uint32
+		arrival   time.Duration
+	}
+
+	tests := []struct {
+		name     string
+		packets  []packet
+		expected uint64
+		lost     int64
+		jitter   time.Duration
+	}{
+		{"no packets", nil, 0, 0, 0},
+		{"steady stream", []packet{{1, 0, 0}, {2, 960, 20 * time.Millisecond}, {3, 1920, 40 * time.Millisecond}}, 3, 0, 0},
+		{"lost packet", []packet{{1, 0, 0}, {2, 960, 20 * time.Millisecond}, {4, 2880, 60 * time.Millisecond}}, 4, 1, 0},
+		{"reordered packets", []packet{{1, 0, 0}, {3, 1920, 40 * time.Millisecond}, {2, 960, 20 * time.Millisecond}}, 3, 0, 0},
+		{"duplicate packet", []packet{{1, 0, 0}, {1, 0, 0}}, 1, -1, 0},
+		{"sequence number wraps", []packet{{65534, 0, 0}, {65535, 960, 20 * time.Millisecond}, {0, 1920, 40 * time.Millisecond}, {1, 2880, 60 * time.Millisecond}}, 4, 0, 0},
+		{"timestamp wraps", []packet{{1, 0xfffffc40, 0}, {2, 0, 20 * time.Millisecond}}, 2, 0, 0},
+		{"arrival far from the first packet", []packet{{1, 0, 0}, {2, uint32(24 * 60 * 60 * 48000 % (1 << 32)), 24 * time.Hour}}, 2, 0, 0},
+		{"late packet", []packet{{1, 0, 0}, {2, 960, 30 * time.Millisecond}}, 2, 0, 625 * time.Microsecond},
+		{"early packet", []packet{{1, 0, 0}, {2, 960, 10 * time.Millisecond}}, 2, 0, 625 * time.Microsecond},
+	}
+
+	start := time.Now()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stats := NewStreamStats(48000)
+			for _, p := range test.packets {
+				stats.Update(&rtp.Header{SequenceNumber: p.seq, Timestamp: p.timestamp}, start.Add(p.arrival))
+			}
+
+			if expected := stats.Expected(); expected != test.expected {
+				t.Errorf("Expected() = %d, expected %d", expected, test.expected)
+			}
+			if lost := stats.Lost(); lost != test.lost {
+				t.Errorf("Lost() = %d, expected %d", lost, test.lost)
+			}
+			if jitter := stats.Jitter(); jitter != test.jitter {
+				t.Errorf("Jitter() = %s, expected %s", jitter, test.jitter)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package types
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/google/uuid"
+)
+
+// A random token that can't be guessed from anything the server sends to peers.
+func NewToken() string {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return uuid.New().String()
+	}
+	return hex.EncodeToString(token)
+}
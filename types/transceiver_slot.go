@@ -0,0 +1,29 @@
+package types
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v3"
+)
+
+// A sendonly transceiver on a listener's peer connection. It carries one speaker's audio at
+// a time and is kept in the listener's pool between speakers so that it can be reused
+// without adding an m-line.
+type TransceiverSlot struct {
+	Transceiver *webrtc.RTPTransceiver
+
+	// The speaker whose audio the slot carries and its bundle, nil while the slot is idle
+	Speaker *Client
+	Bundle  *AudioBundle
+
+	// Mutex to lock the speaker and bundle
+	Mutex sync.RWMutex
+}
+
+// Sent in track_mapping events when a reused transceiver starts carrying a speaker's audio.
+// The listener's stream id for the m-line is whatever it was first negotiated with.
+type TrackMapping struct {
+	Mid  string
+	UUID uuid.UUID
+}
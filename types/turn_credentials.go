@@ -0,0 +1,17 @@
+package types
+
+import "github.com/pion/webrtc/v3"
+
+// Time limited TURN credentials in the shape of the TURN REST API
+// (draft-uberti-behave-turn-rest), so that existing clients can consume them.
+type TURNCredentials struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"`
+	URIs     []string `json:"uris"`
+}
+
+// The data sent with ice_servers events.
+type ICEServersPayload struct {
+	ICEServers []webrtc.ICEServer
+}
@@ -0,0 +1,17 @@
+package types
+
+import "sync"
+
+// Allocation counts for the embedded TURN server.
+type TURNStats struct {
+	// Relays currently allocated
+	Allocations int
+
+	// Relays allocated since the server started
+	TotalAllocations uint64
+
+	// Allocations that couldn't be made, usually because the relay port range is used up
+	FailedAllocations uint64
+
+	Mutex sync.Mutex `json:"-"`
+}
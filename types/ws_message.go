@@ -1,6 +1,80 @@
 package types
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 type WebsocketMessage struct {
 	Event string `json:"event"`
-	Data  string `json:"data"`
+
+	// Data as the client sent it, or as version 1 clients are sent it for events whose
+	// legacy data isn't JSON
+	Data string `json:"data"`
+
+	// The event's payload struct for events the server sends, encoded for the client's
+	// protocol version when the message is written
+	Payload interface{} `json:"-"`
+
+	// Optional, set by the client on events it wants acknowledged and echoed in the ack or error.
+	ID json.RawMessage `json:"id,omitempty"`
+}
+
+// Accept data either as a JSON encoded string (version 1) or as the JSON value itself.
+func (m *WebsocketMessage) UnmarshalJSON(raw []byte) error {
+	message := struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+		ID    json.RawMessage `json:"id"`
+	}{}
+
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return err
+	}
+
+	m.Event = message.Event
+	m.Data = ""
+	m.ID = nil
+	if id := bytes.TrimSpace(message.ID); len(id) > 0 && !bytes.Equal(id, []byte("null")) {
+		m.ID = id
+	}
+
+	data := bytes.TrimSpace(message.Data)
+	switch {
+	case len(data) == 0 || bytes.Equal(data, []byte("null")):
+	case data[0] == '"':
+		return json.Unmarshal(data, &m.Data)
+	default:
+		m.Data = string(data)
+	}
+
+	return nil
+}
+
+// The message as sent to a client speaking the protocol version. From version 2 on the
+// payload is embedded in data as a JSON value, before that it is sent as a JSON encoded
+// string unless the message carries legacy data for version 1.
+func (m *WebsocketMessage) Encode(version int) (interface{}, error) {
+	if m.Payload == nil || (version < 2 && m.Data != "") {
+		return m, nil
+	}
+
+	data, err := json.Marshal(m.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if version < 2 {
+		return &WebsocketMessage{Event: m.Event, Data: string(data), ID: m.ID}, nil
+	}
+
+	return &struct {
+		Event string          `json:"event"`
+		Data  json.RawMessage `json:"data"`
+		ID    json.RawMessage `json:"id,omitempty"`
+	}{
+		Event: m.Event,
+		Data:  data,
+		ID:    m.ID,
+	}, nil
 }
@@ -0,0 +1,86 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestWebsocketMessageUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		event string
+		data  string
+		id    string
+		err   bool
+	}{
+		{"string data", `{"event":"mute","data":"true"}`, "mute", "true", "", false},
+		{"object data", `{"event":"hello","data":{"Version":2}}`, "hello", `{"Version":2}`, "", false},
+		{"encoded object data", `{"event":"hello","data":"{\"Version\":2}"}`, "hello", `{"Version":2}`, "", false},
+		{"number data", `{"event":"bitrate","data": 32000 }`, "bitrate", "32000", "", false},
+		{"null data", `{"event":"stop_recording","data":null}`, "stop_recording", "", "", false},
+		{"missing data", `{"event":"stop_recording"}`, "stop_recording", "", "", false},
+		{"numeric id", `{"event":"mute","data":"true","id":7}`, "mute", "true", "7", false},
+		{"string id", `{"event":"mute","data":"true","id":"a"}`, "mute", "true", `"a"`, false},
+		{"null id", `{"event":"mute","data":"true","id":null}`, "mute", "true", "", false},
+		{"not an object", `"mute"`, "", "", "", true},
+		{"malformed", `{"event":`, "", "", "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			message := &WebsocketMessage{}
+			err := json.Unmarshal([]byte(test.raw), message)
+			if (err != nil) != test.err {
+				t.Fatalf("Unmarshal() error = %v, expected error %t", err, test.err)
+			}
+			if test.err {
+				return
+			}
+
+			if message.Event != test.event || message.Data != test.data || string(message.ID) != test.id {
+				t.Errorf("Unmarshal() = %q, %q, %q, expected %q, %q, %q",
+					message.Event, message.Data, message.ID, test.event, test.data, test.id)
+			}
+		})
+	}
+}
+
+func TestWebsocketMessageEncode(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  *WebsocketMessage
+		version  int
+		expected string
+		err      bool
+	}{
+		{"data without payload", &WebsocketMessage{Event: "peer", Data: "x"}, 2, `{"event":"peer","data":"x"}`, false},
+		{"payload as a string for version 1", &WebsocketMessage{Event: "ack", Payload: &AckPayload{Event: "mute"}}, 1, `{"event":"ack","data":"{\"Event\":\"mute\"}"}`, false},
+		{"payload as a value for version 2", &WebsocketMessage{Event: "ack", Payload: &AckPayload{Event: "mute"}}, 2, `{"event":"ack","data":{"Event":"mute"}}`, false},
+		{"legacy data for version 1", &WebsocketMessage{Event: "peer_disconnected", Data: "abc", Payload: &AckPayload{Event: "x"}}, 1, `{"event":"peer_disconnected","data":"abc"}`, false},
+		{"payload over legacy data for version 2", &WebsocketMessage{Event: "peer_disconnected", Data: "abc", Payload: &AckPayload{Event: "x"}}, 2, `{"event":"peer_disconnected","data":{"Event":"x"}}`, false},
+		{"id echoed for version 1", &WebsocketMessage{Event: "ack", Payload: &AckPayload{Event: "mute"}, ID: json.RawMessage("7")}, 1, `{"event":"ack","data":"{\"Event\":\"mute\"}","id":7}`, false},
+		{"id echoed for version 2", &WebsocketMessage{Event: "ack", Payload: &AckPayload{Event: "mute"}, ID: json.RawMessage(`"a"`)}, 2, `{"event":"ack","data":{"Event":"mute"},"id":"a"}`, false},
+		{"unencodable payload", &WebsocketMessage{Event: "ack", Payload: make(chan bool)}, 2, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoded, err := test.message.Encode(test.version)
+			if (err != nil) != test.err {
+				t.Fatalf("Encode() error = %v, expected error %t", err, test.err)
+			}
+			if test.err {
+				return
+			}
+
+			marshaled, err := json.Marshal(encoded)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(marshaled) != test.expected {
+				t.Errorf("Encode() = %s, expected %s", marshaled, test.expected)
+			}
+		})
+	}
+}